@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+
 	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
 	dockerOperator "github.com/slntopp/nocloud-operator/pkg/operator"
@@ -9,6 +11,9 @@ import (
 	"github.com/slntopp/nocloud/pkg/nocloud/schema"
 	"go.uber.org/zap"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var (
@@ -24,6 +29,22 @@ func main() {
 		_ = log.Sync()
 	}()
 
+	// Updater container runs the same binary to replace operator container
+	if len(os.Args) == 5 && os.Args[1] == dockerOperator.SelfUpdateCommand {
+		timeout, err := time.ParseDuration(os.Args[3])
+		if err != nil {
+			log.Fatal("Wrong self update timeout", zap.Error(err))
+		}
+		stopTimeout, err := time.ParseDuration(os.Args[4])
+		if err != nil {
+			log.Fatal("Wrong operator stop timeout", zap.Error(err))
+		}
+		if err := dockerOperator.RunSelfUpdate(context.Background(), log, os.Args[2], timeout, stopTimeout); err != nil {
+			log.Fatal("Self update failed", zap.Error(err))
+		}
+		return
+	}
+
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file", zap.Error(err))
@@ -44,18 +65,54 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	operator := dockerOperator.NewOperator(log, token)
-	err = operator.ConfigureDns()
+	defer operator.Close()
+
+	// Operator gets shutdown timeout to finish recreations in progress, then is killed
+	go func() {
+		<-ctx.Done()
+		log.Info("Shutting down", zap.Duration("timeout", operator.ShutdownTimeout()))
+		time.AfterFunc(operator.ShutdownTimeout(), func() {
+			log.Error("Shutdown timeout exceeded")
+			_ = log.Sync()
+			os.Exit(1)
+		})
+	}()
+
+	err = operator.SetupLeaderElection(rdb)
 	if err != nil {
-		log.Fatal("Error Configuring DNS", zap.Error(err))
+		log.Fatal("Error Setting up leader election", zap.Error(err))
 	}
+	go operator.RunLeaderElection(ctx)
+	go operator.ServeStatus(ctx)
 
-	err = operator.SetDnsIpToContainers()
+	err = operator.ConfigureDns(ctx)
 	if err != nil {
+		log.Fatal("Error Configuring DNS", zap.Error(err))
+	}
+	operator.ConfirmSelfUpdate(ctx)
+
+	// Followers don't change anything, so startup changes wait for leadership
+	if !operator.AwaitLeadership(ctx) {
+		log.Info("Operator stopped")
+		return
+	}
+
+	// Startup changes stop if leadership is lost meanwhile, operator loop takes over then
+	leaderCtx, cancelLeader := operator.LeaderContext(ctx)
+	err = operator.SetDnsIpToContainers(leaderCtx)
+	if err != nil && leaderCtx.Err() == nil {
 		log.Fatal("Error Set Ip DNS", zap.Error(err))
 	}
 
-	containers := operator.Ps()
+	operator.SweepDnsRecords(leaderCtx)
+	operator.UpComposeServices(leaderCtx)
+	cancelLeader()
+
+	containers := operator.Ps(ctx)
 	for _, container := range containers {
 		log.Info("Found Container", zap.String("name", container.Names[0]), zap.String("image", container.Image), zap.String("id", container.ShortId))
 	}
@@ -67,5 +124,6 @@ func main() {
 		}
 	*/
 
-	operator.ObserveContainers()
+	operator.ObserveContainers(ctx)
+	log.Info("Operator stopped")
 }
@@ -7,6 +7,8 @@ require (
 	github.com/docker/go-connections v0.5.0
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/joho/godotenv v1.5.1
+	github.com/miekg/dns v1.1.57
+	github.com/moby/patternmatcher v0.6.0
 	github.com/slntopp/nocloud v0.0.18
 	github.com/slntopp/nocloud-proto v0.0.0-20230928084001-11a2827103dc
 	go.uber.org/zap v1.27.0
@@ -2,81 +2,73 @@ package dns
 
 import (
 	"context"
-	"fmt"
-	"os"
-	"time"
 
-	"google.golang.org/grpc/credentials/insecure"
-
-	"github.com/slntopp/nocloud-proto/dns"
 	"go.uber.org/zap"
-	"google.golang.org/grpc"
 )
 
 type DnsWrap struct {
-	Network   string
-	DnsIp     string
-	DnsClient dns.DNSClient
+	Network  string
+	DnsIp    string
+	Provider Provider
+	// Audit is where "Was changed by operator" TXT is written: next to owner marker (AuditInline),
+	// under _audit.<name> (AuditSeparate) or nowhere (AuditOff)
+	Audit string
 
 	log *zap.Logger
 }
 
-func NewDnsWrap(log *zap.Logger, network, dnsIp, dnsMgmtHost string) *DnsWrap {
-	port := os.Getenv("DNS_MGMT_PORT")
-	if port == "" {
-		port = "8000"
-	}
+func NewDnsWrap(log *zap.Logger, network, dnsIp string, provider Provider) *DnsWrap {
+	return &DnsWrap{Network: network, DnsIp: dnsIp, Provider: provider, log: log}
+}
 
-	host := fmt.Sprintf("%s:%s", dnsMgmtHost, port)
+func (d *DnsWrap) Close() error {
+	return d.Provider.Close()
+}
 
-	conn, err := grpc.Dial(host, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		log.Fatal(err.Error())
+// Supports tells if provider can keep records of type
+func (d *DnsWrap) Supports(rrtype uint16) bool {
+	if provider, ok := d.Provider.(typedProvider); ok {
+		return provider.Supports(rrtype)
 	}
-
-	dnsClient := dns.NewDNSClient(conn)
-	return &DnsWrap{Network: network, DnsIp: dnsIp, DnsClient: dnsClient, log: log}
+	return true
 }
 
-func (d *DnsWrap) Get(ctx context.Context, zoneName string, ip string, aValue string) error {
-	log := d.log.Named("get")
+// Sweep deletes locations owned by operator instance which aren't in desired names of their zone
+func (d *DnsWrap) Sweep(ctx context.Context, instance string, desired map[string]map[string]struct{}) error {
+	log := d.log.Named("sweep")
 
-	zone := dns.Zone{Name: zoneName}
-	get, err := d.DnsClient.Get(ctx, &zone)
+	zones, err := d.Provider.Zones(ctx)
 	if err != nil {
 		return err
 	}
 
-	log.Info("Locations", zap.Any("locs", get.Locations))
-
-	if get.Locations == nil {
-		get.Locations = make(map[string]*dns.Record)
-	}
-
-	location, ok := get.Locations[aValue]
-	if !ok {
-		location = &dns.Record{A: make([]*dns.Record_A, 1), Txt: make([]*dns.Record_TXT, 1)}
-		location.A[0] = &dns.Record_A{}
-		location.Txt[0] = &dns.Record_TXT{}
+	owner := Owner{Instance: instance}
+	for _, zoneName := range zones {
+		locations, err := d.Provider.Get(ctx, zoneName)
+		if err != nil {
+			return err
+		}
+
+		var orphans []string
+		for name := range locations {
+			if _, ok := desired[zoneName][name]; ok || markerName(name) {
+				continue
+			}
+			if ownedBy(locations, name, owner) {
+				orphans = append(orphans, name)
+			}
+		}
+		if len(orphans) == 0 {
+			continue
+		}
+		for _, name := range orphans {
+			deleteLocation(locations, name)
+		}
+
+		if err := d.Provider.Put(ctx, zoneName, locations); err != nil {
+			return err
+		}
+		log.Info("Removed orphaned DNS Records", zap.String("zone", zoneName), zap.Strings("names", orphans))
 	}
-
-	if len(location.A) == 0 {
-		location.A = append(location.A, &dns.Record_A{})
-	}
-
-	if len(location.Txt) == 0 {
-		location.Txt = append(location.Txt, &dns.Record_TXT{})
-	}
-	location.A[0].Ip = ip
-	location.A[0].Ttl = 300
-	location.Txt[0] = &dns.Record_TXT{Text: "Was changed by operator at " + time.Now().UTC().String(), Ttl: 300}
-	get.Locations[aValue] = location
-
-	put, err := d.DnsClient.Put(ctx, get)
-	if err != nil {
-		return err
-	}
-
-	log.Info("Put DNS Record", zap.Int64("result", put.Result))
 	return nil
 }
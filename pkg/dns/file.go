@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	FormatHosts = "hosts"
+	FormatZone  = "zone"
+
+	stateExtension = ".json"
+)
+
+// FileProvider keeps records in files read by CoreDNS: hosts files for hosts plugin or zone files
+// for file plugin. Records are also kept as JSON next to them, as hosts files can't hold all record types
+type FileProvider struct {
+	dir    string
+	format string
+}
+
+func NewFileProvider(dir, format string) (*FileProvider, error) {
+	switch format {
+	case "":
+		format = FormatZone
+	case FormatHosts, FormatZone:
+	default:
+		return nil, fmt.Errorf("unknown zone file format %s", format)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileProvider{dir: dir, format: format}, nil
+}
+
+func (p *FileProvider) Zones(ctx context.Context) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(p.dir, "*"+stateExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	zones := make([]string, 0, len(files))
+	for _, file := range files {
+		zones = append(zones, strings.TrimSuffix(filepath.Base(file), stateExtension))
+	}
+	return zones, nil
+}
+
+func (p *FileProvider) Get(ctx context.Context, zone string) (map[string]*Record, error) {
+	locations := map[string]*Record{}
+
+	data, err := os.ReadFile(p.path(zone, stateExtension))
+	if os.IsNotExist(err) {
+		return locations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &locations); err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
+func (p *FileProvider) Put(ctx context.Context, zone string, locations map[string]*Record) error {
+	data, err := json.Marshal(locations)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(p.path(zone, stateExtension), data); err != nil {
+		return err
+	}
+
+	if p.format == FormatHosts {
+		return writeFile(p.path(zone, "."+FormatHosts), hostsFile(zone, locations))
+	}
+	return writeFile(p.path(zone, ".db"), zoneFile(zone, locations))
+}
+
+func (p *FileProvider) Close() error {
+	return nil
+}
+
+func (p *FileProvider) path(zone, extension string) string {
+	return filepath.Join(p.dir, strings.TrimSuffix(zone, ".")+extension)
+}
+
+// hostsFile renders A and AAAA records, the only ones hosts file can hold
+func hostsFile(zone string, locations map[string]*Record) []byte {
+	var buf bytes.Buffer
+	for _, name := range sortedNames(locations) {
+		for _, a := range locations[name].A {
+			fmt.Fprintf(&buf, "%s %s\n", a.Ip, fqdn(zone, name))
+		}
+		for _, aaaa := range locations[name].Aaaa {
+			fmt.Fprintf(&buf, "%s %s\n", aaaa.Ip, fqdn(zone, name))
+		}
+	}
+	return buf.Bytes()
+}
+
+// zoneFile renders zone with generated SOA, its serial is changed on every write so secondaries pick changes
+func zoneFile(zone string, locations map[string]*Record) []byte {
+	soa := soaRR(zone, uint32(time.Now().Unix()))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "$ORIGIN %s\n%s\n", soa.Hdr.Name, soa.String())
+	for _, name := range sortedNames(locations) {
+		var lines []string
+		for _, rr := range recordRRs(zone, name, locations[name]) {
+			lines = append(lines, rr.String())
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			buf.WriteString(line + "\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// writeFile replaces file at once, so DNS server never reads it half written
+func writeFile(path string, data []byte) error {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -1,7 +1,21 @@
 package dns
 
 const (
-	UpdateLabel = "nocloud.update"
+	UpdateLabel         = "nocloud.update"
+	UpdateIntervalLabel = "nocloud.update.interval"
+	ReconcileLabel      = "nocloud.reconcile"
+
+	BuildWatchLabel = "nocloud.build.watch"
+
+	CrashLoopActionLabel = "nocloud.crashloop.action"
+	PreviousImageLabel   = "nocloud.image.previous"
+	PinnedImageLabel     = "nocloud.image.pinned"
+
+	HealthActionLabel    = "nocloud.health.action"
+	HealthThresholdLabel = "nocloud.health.threshold"
+
+	RestartOnLabel     = "nocloud.restart.on"
+	RestartActionLabel = "nocloud.restart.action"
 
 	ServerLabel      = "nocloud.dns.server"
 	ApiLabel         = "nocloud.dns.api"
@@ -14,6 +28,9 @@ const (
 	AAAALabel  = "nocloud.dns.key.aaaa"
 	CNameLabel = "nocloud.dns.key.cname"
 	TxtLabel   = "nocloud.dns.key.txt"
+	PtrLabel   = "nocloud.dns.ptr"
+	SrvLabel   = "nocloud.dns.srv"
+	TtlLabel   = "nocloud.dns.ttl"
 
 	DriverLabel      = "nocloud.driver"
 	WithDriversLabel = "nocloud.with_drivers"
@@ -0,0 +1,77 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	mdns "github.com/miekg/dns"
+	"github.com/slntopp/nocloud-proto/dns"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// MgmtProvider keeps records in NoCloud dns-mgmt over gRPC
+type MgmtProvider struct {
+	client dns.DNSClient
+	conn   *grpc.ClientConn
+}
+
+func NewMgmtProvider(dnsMgmtHost string) (*MgmtProvider, error) {
+	port := os.Getenv("DNS_MGMT_PORT")
+	if port == "" {
+		port = "8000"
+	}
+
+	host := fmt.Sprintf("%s:%s", dnsMgmtHost, port)
+
+	conn, err := grpc.Dial(host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &MgmtProvider{client: dns.NewDNSClient(conn), conn: conn}, nil
+}
+
+func (p *MgmtProvider) Zones(ctx context.Context) ([]string, error) {
+	list, err := p.client.List(ctx, &dns.ListRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Zones, nil
+}
+
+func (p *MgmtProvider) Get(ctx context.Context, zone string) (map[string]*Record, error) {
+	get, err := p.client.Get(ctx, &dns.Zone{Name: zone})
+	if err != nil {
+		return nil, err
+	}
+
+	locations := make(map[string]*Record, len(get.Locations))
+	for name, record := range get.Locations {
+		locations[name] = &Record{A: record.A, Aaaa: record.Aaaa, Cname: record.Cname, Txt: record.Txt}
+	}
+	return locations, nil
+}
+
+func (p *MgmtProvider) Put(ctx context.Context, zone string, locations map[string]*Record) error {
+	records := make(map[string]*dns.Record, len(locations))
+	for name, record := range locations {
+		records[name] = &dns.Record{A: record.A, Aaaa: record.Aaaa, Cname: record.Cname, Txt: record.Txt}
+	}
+	_, err := p.client.Put(ctx, &dns.Zone{Name: zone, Locations: records})
+	return err
+}
+
+// Supports reports types dns-mgmt API can keep: A, AAAA, CNAME and TXT
+func (p *MgmtProvider) Supports(rrtype uint16) bool {
+	switch rrtype {
+	case mdns.TypeA, mdns.TypeAAAA, mdns.TypeCNAME, mdns.TypeTXT:
+		return true
+	}
+	return false
+}
+
+func (p *MgmtProvider) Close() error {
+	return p.conn.Close()
+}
@@ -0,0 +1,18 @@
+package dns
+
+import (
+	"context"
+)
+
+// Provider keeps records of zones. Records are passed as locations, keyed by name relative to zone
+type Provider interface {
+	Zones(ctx context.Context) ([]string, error)
+	Get(ctx context.Context, zone string) (map[string]*Record, error)
+	Put(ctx context.Context, zone string, locations map[string]*Record) error
+	Close() error
+}
+
+// typedProvider is implemented by providers which can keep only some record types
+type typedProvider interface {
+	Supports(rrtype uint16) bool
+}
@@ -0,0 +1,192 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+	"github.com/slntopp/nocloud-proto/dns"
+)
+
+const (
+	defaultTtl  = 300
+	srvWeight   = 10
+	auditPrefix = "Was changed by operator at "
+	heritage    = "nocloud-operator"
+	ownerPrefix = "_owner."
+	auditName   = "_audit."
+
+	AuditInline   = "inline"
+	AuditSeparate = "separate"
+	AuditOff      = "off"
+)
+
+// TTL is time to live of location records in seconds by record type, types which aren't set take Default.
+// Zero Default means 300
+type TTL struct {
+	Default int32
+	Types   map[uint16]int32
+}
+
+func (t TTL) Of(rrtype uint16) int32 {
+	if ttl, ok := t.Types[rrtype]; ok {
+		return ttl
+	}
+	if t.Default > 0 {
+		return t.Default
+	}
+	return defaultTtl
+}
+
+// Record is set of records kept under one name. It mirrors dns-mgmt record, adding types dns-mgmt doesn't know
+type Record struct {
+	A     []*dns.Record_A     `json:"a,omitempty"`
+	Aaaa  []*dns.Record_AAAA  `json:"aaaa,omitempty"`
+	Cname []*dns.Record_CNAME `json:"cname,omitempty"`
+	Txt   []*dns.Record_TXT   `json:"txt,omitempty"`
+	Ptr   []*RecordPTR        `json:"ptr,omitempty"`
+	Srv   []*RecordSRV        `json:"srv,omitempty"`
+}
+
+type RecordPTR struct {
+	Host string `json:"host,omitempty"`
+	Ttl  int32  `json:"ttl,omitempty"`
+}
+
+type RecordSRV struct {
+	Target   string `json:"target,omitempty"`
+	Port     uint16 `json:"port,omitempty"`
+	Priority uint16 `json:"priority,omitempty"`
+	Weight   uint16 `json:"weight,omitempty"`
+	Ttl      int32  `json:"ttl,omitempty"`
+}
+
+// SRV is service published by container at port of its name
+type SRV struct {
+	Target string
+	Port   uint16
+}
+
+// Location is set of records published by container under one name in zone
+type Location struct {
+	A     []string
+	AAAA  []string
+	CNAME string
+	TXT   []string
+	PTR   []string
+	SRV   []SRV
+	TTL   TTL
+}
+
+// Record converts location to record, without audit TXT
+func (l *Location) Record() *Record {
+	record := &Record{}
+	for _, ip := range l.A {
+		record.A = append(record.A, &dns.Record_A{Ip: ip, Ttl: l.TTL.Of(mdns.TypeA)})
+	}
+	for _, ip := range l.AAAA {
+		record.Aaaa = append(record.Aaaa, &dns.Record_AAAA{Ip: ip, Ttl: l.TTL.Of(mdns.TypeAAAA)})
+	}
+	if l.CNAME != "" {
+		record.Cname = append(record.Cname, &dns.Record_CNAME{Host: l.CNAME, Ttl: l.TTL.Of(mdns.TypeCNAME)})
+	}
+	for _, text := range l.TXT {
+		record.Txt = append(record.Txt, &dns.Record_TXT{Text: text, Ttl: l.TTL.Of(mdns.TypeTXT)})
+	}
+	for _, host := range l.PTR {
+		record.Ptr = append(record.Ptr, &RecordPTR{Host: host, Ttl: l.TTL.Of(mdns.TypePTR)})
+	}
+	for _, srv := range l.SRV {
+		record.Srv = append(record.Srv, &RecordSRV{Target: srv.Target, Port: srv.Port, Weight: srvWeight, Ttl: l.TTL.Of(mdns.TypeSRV)})
+	}
+	return record
+}
+
+// Owner marks records written by operator instance for container, same way external-dns does
+type Owner struct {
+	Instance  string
+	Container string
+}
+
+func (o Owner) txt(ttl int32) *dns.Record_TXT {
+	return &dns.Record_TXT{
+		Text: fmt.Sprintf("heritage=%s,owner=%s,container=%s", heritage, o.Instance, o.Container),
+		Ttl:  ttl,
+	}
+}
+
+func parseOwner(text string) (Owner, bool) {
+	var owner Owner
+	fields := map[string]string{}
+	for _, field := range strings.Split(text, ",") {
+		key, value, _ := strings.Cut(field, "=")
+		fields[key] = value
+	}
+	if fields["heritage"] != heritage {
+		return owner, false
+	}
+	owner.Instance, owner.Container = fields["owner"], fields["container"]
+	return owner, true
+}
+
+// ownerName is name owner marker of location is kept under, CNAME can't share name with other records
+func ownerName(name string, record *Record) string {
+	if len(record.Cname) != 0 {
+		return ownerPrefix + name
+	}
+	return name
+}
+
+// markerName tells if name holds owner marker or audit of other location rather than records
+func markerName(name string) bool {
+	return strings.HasPrefix(name, ownerPrefix) || strings.HasPrefix(name, auditName)
+}
+
+// deleteLocation deletes location along with its owner marker and audit
+func deleteLocation(locations map[string]*Record, name string) {
+	delete(locations, name)
+	delete(locations, ownerPrefix+name)
+	delete(locations, auditName+name)
+}
+
+// locationOwner finds owner marker of location in zone. Records written before markers were
+// introduced are recognized by audit TXT and taken as owned by any instance
+func locationOwner(locations map[string]*Record, name string) (Owner, bool) {
+	record, ok := locations[name]
+	if !ok {
+		return Owner{}, false
+	}
+	marker, ok := locations[ownerName(name, record)]
+	if !ok {
+		return Owner{}, false
+	}
+
+	legacy := false
+	for _, txt := range marker.Txt {
+		if owner, ok := parseOwner(txt.Text); ok {
+			return owner, true
+		}
+		legacy = legacy || strings.HasPrefix(txt.Text, auditPrefix)
+	}
+	return Owner{}, legacy
+}
+
+// ownedBy tells if location is owned by operator instance and, if container is given, by that container.
+// Only such locations are deleted
+func ownedBy(locations map[string]*Record, name string, owner Owner) bool {
+	current, ok := locationOwner(locations, name)
+	if !ok {
+		return false
+	}
+	return current.Instance == owner.Instance && (owner.Container == "" || current.Container == owner.Container)
+}
+
+// takeable tells if location may be overwritten by operator instance: it owns it, or location was written
+// before owner markers (audit TXT only), so any instance desiring it takes it over
+func takeable(locations map[string]*Record, name, instance string) bool {
+	current, ok := locationOwner(locations, name)
+	if !ok {
+		return false
+	}
+	return current.Instance == "" || current.Instance == instance
+}
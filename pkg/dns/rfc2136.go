@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+const tsigFudge = 300
+
+// Rfc2136Provider keeps records on authoritative server (BIND, Knot, PowerDNS) using dynamic updates,
+// zone is read by zone transfer. Both are signed with TSIG key if it's given
+type Rfc2136Provider struct {
+	server    string
+	zones     []string
+	key       string
+	secret    string
+	algorithm string
+}
+
+func NewRfc2136Provider(server string, zones []string, key, secret, algorithm string) *Rfc2136Provider {
+	if algorithm == "" {
+		algorithm = mdns.HmacSHA256
+	}
+	return &Rfc2136Provider{
+		server:    server,
+		zones:     zones,
+		key:       mdns.Fqdn(key),
+		secret:    secret,
+		algorithm: mdns.Fqdn(algorithm),
+	}
+}
+
+// Zones are configured, as RFC 2136 has no way to list zones of server
+func (p *Rfc2136Provider) Zones(ctx context.Context) ([]string, error) {
+	return p.zones, nil
+}
+
+func (p *Rfc2136Provider) Get(ctx context.Context, zone string) (map[string]*Record, error) {
+	msg := new(mdns.Msg)
+	msg.SetAxfr(mdns.Fqdn(zone))
+
+	transfer := new(mdns.Transfer)
+	if p.secret != "" {
+		msg.SetTsig(p.key, p.algorithm, tsigFudge, time.Now().Unix())
+		transfer.TsigSecret = map[string]string{p.key: p.secret}
+	}
+
+	envelopes, err := transfer.In(msg, p.server)
+	if err != nil {
+		return nil, err
+	}
+
+	locations := map[string]*Record{}
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, envelope.Error
+		}
+		for _, rr := range envelope.RR {
+			addRR(locations, zone, rr)
+		}
+	}
+	return locations, nil
+}
+
+// Put sends update replacing record sets of changed names
+func (p *Rfc2136Provider) Put(ctx context.Context, zone string, locations map[string]*Record) error {
+	current, err := p.Get(ctx, zone)
+	if err != nil {
+		return err
+	}
+
+	msg := new(mdns.Msg)
+	msg.SetUpdate(mdns.Fqdn(zone))
+	for name, record := range current {
+		if recordKey(zone, name, record) == recordKey(zone, name, locations[name]) {
+			continue
+		}
+		msg.RemoveRRset(recordRRs(zone, name, record))
+	}
+	for name, record := range locations {
+		if recordKey(zone, name, record) == recordKey(zone, name, current[name]) {
+			continue
+		}
+		msg.Insert(recordRRs(zone, name, record))
+	}
+	if len(msg.Ns) == 0 {
+		return nil
+	}
+
+	client := new(mdns.Client)
+	if p.secret != "" {
+		msg.SetTsig(p.key, p.algorithm, tsigFudge, time.Now().Unix())
+		client.TsigSecret = map[string]string{p.key: p.secret}
+	}
+
+	reply, _, err := client.ExchangeContext(ctx, msg, p.server)
+	if err != nil {
+		return err
+	}
+	if reply.Rcode != mdns.RcodeSuccess {
+		return fmt.Errorf("update of zone %s failed: %s", zone, mdns.RcodeToString[reply.Rcode])
+	}
+	return nil
+}
+
+func (p *Rfc2136Provider) Close() error {
+	return nil
+}
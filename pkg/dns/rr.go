@@ -0,0 +1,145 @@
+package dns
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+	"github.com/slntopp/nocloud-proto/dns"
+)
+
+const apex = "@"
+
+// fqdn makes absolute name of location in zone
+func fqdn(zone, name string) string {
+	if name == apex || name == "" {
+		return mdns.Fqdn(zone)
+	}
+	return mdns.Fqdn(name + "." + zone)
+}
+
+// relativeName makes location name of absolute name in zone
+func relativeName(zone, name string) string {
+	name, zone = strings.ToLower(mdns.Fqdn(name)), strings.ToLower(mdns.Fqdn(zone))
+	if name == zone {
+		return apex
+	}
+	return strings.TrimSuffix(name, "."+zone)
+}
+
+// recordRRs converts location records to resource records
+func recordRRs(zone, name string, record *Record) []mdns.RR {
+	header := func(rrtype uint16, ttl int32) mdns.RR_Header {
+		return mdns.RR_Header{Name: fqdn(zone, name), Rrtype: rrtype, Class: mdns.ClassINET, Ttl: uint32(ttl)}
+	}
+
+	var rrs []mdns.RR
+	for _, a := range record.A {
+		rrs = append(rrs, &mdns.A{Hdr: header(mdns.TypeA, a.Ttl), A: net.ParseIP(a.Ip)})
+	}
+	for _, aaaa := range record.Aaaa {
+		rrs = append(rrs, &mdns.AAAA{Hdr: header(mdns.TypeAAAA, aaaa.Ttl), AAAA: net.ParseIP(aaaa.Ip)})
+	}
+	for _, cname := range record.Cname {
+		rrs = append(rrs, &mdns.CNAME{Hdr: header(mdns.TypeCNAME, cname.Ttl), Target: mdns.Fqdn(cname.Host)})
+	}
+	for _, txt := range record.Txt {
+		rrs = append(rrs, &mdns.TXT{Hdr: header(mdns.TypeTXT, txt.Ttl), Txt: []string{txt.Text}})
+	}
+	for _, ptr := range record.Ptr {
+		rrs = append(rrs, &mdns.PTR{Hdr: header(mdns.TypePTR, ptr.Ttl), Ptr: mdns.Fqdn(ptr.Host)})
+	}
+	for _, srv := range record.Srv {
+		rrs = append(rrs, &mdns.SRV{
+			Hdr:      header(mdns.TypeSRV, srv.Ttl),
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+			Port:     srv.Port,
+			Target:   mdns.Fqdn(srv.Target),
+		})
+	}
+	return rrs
+}
+
+// addRR adds resource record to locations of zone, types which can't be kept in location are skipped
+func addRR(locations map[string]*Record, zone string, rr mdns.RR) {
+	name := relativeName(zone, rr.Header().Name)
+	ttl := int32(rr.Header().Ttl)
+
+	record, ok := locations[name]
+	if !ok {
+		record = &Record{}
+	}
+	switch rr := rr.(type) {
+	case *mdns.A:
+		record.A = append(record.A, &dns.Record_A{Ip: rr.A.String(), Ttl: ttl})
+	case *mdns.AAAA:
+		record.Aaaa = append(record.Aaaa, &dns.Record_AAAA{Ip: rr.AAAA.String(), Ttl: ttl})
+	case *mdns.CNAME:
+		record.Cname = append(record.Cname, &dns.Record_CNAME{Host: rr.Target, Ttl: ttl})
+	case *mdns.TXT:
+		record.Txt = append(record.Txt, &dns.Record_TXT{Text: strings.Join(rr.Txt, ""), Ttl: ttl})
+	case *mdns.PTR:
+		record.Ptr = append(record.Ptr, &RecordPTR{Host: rr.Ptr, Ttl: ttl})
+	case *mdns.SRV:
+		record.Srv = append(record.Srv, &RecordSRV{Target: rr.Target, Port: rr.Port, Priority: rr.Priority, Weight: rr.Weight, Ttl: ttl})
+	default:
+		return
+	}
+	locations[name] = record
+}
+
+// ReverseZone finds reverse zone and name in it of address. Address belongs to the most specific of given
+// zones containing it, otherwise to its /24 zone for IPv4 or /64 zone for IPv6
+func ReverseZone(ip string, zones []string) (string, string, error) {
+	arpa, err := mdns.ReverseAddr(ip)
+	if err != nil {
+		return "", "", err
+	}
+
+	zone := ""
+	for _, item := range zones {
+		item = strings.ToLower(mdns.Fqdn(item))
+		if mdns.IsSubDomain(item, arpa) && len(item) > len(zone) {
+			zone = item
+		}
+	}
+	if zone == "" {
+		labels := mdns.SplitDomainName(arpa)
+		host := 1
+		if strings.HasSuffix(arpa, ".ip6.arpa.") {
+			host = 16
+		}
+		zone = mdns.Fqdn(strings.Join(labels[host:], "."))
+	}
+	return strings.TrimSuffix(zone, "."), relativeName(zone, arpa), nil
+}
+
+// recordKey is comparable form of location records
+func recordKey(zone, name string, record *Record) string {
+	if record == nil {
+		return ""
+	}
+	var lines []string
+	for _, rr := range recordRRs(zone, name, record) {
+		lines = append(lines, rr.String())
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// soaRR is SOA generated for zones operator keeps
+func soaRR(zone string, serial uint32) *mdns.SOA {
+	origin := mdns.Fqdn(zone)
+	return &mdns.SOA{
+		Hdr:     mdns.RR_Header{Name: origin, Rrtype: mdns.TypeSOA, Class: mdns.ClassINET, Ttl: defaultTtl},
+		Ns:      "ns." + origin,
+		Mbox:    "hostmaster." + origin,
+		Serial:  serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  defaultTtl,
+	}
+}
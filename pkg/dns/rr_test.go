@@ -0,0 +1,38 @@
+package dns
+
+import "testing"
+
+func TestReverseZone(t *testing.T) {
+	cases := []struct {
+		ip    string
+		zones []string
+		zone  string
+		name  string
+		err   bool
+	}{
+		{ip: "10.0.0.2", zone: "0.0.10.in-addr.arpa", name: "2"},
+		{ip: "10.0.0.2", zones: []string{"10.in-addr.arpa"}, zone: "10.in-addr.arpa", name: "2.0.0"},
+		{ip: "10.0.0.2", zones: []string{"10.in-addr.arpa", "0.10.in-addr.arpa."}, zone: "0.10.in-addr.arpa", name: "2.0"},
+		{ip: "10.0.0.2", zones: []string{"168.192.in-addr.arpa"}, zone: "0.0.10.in-addr.arpa", name: "2"},
+		{ip: "10.0.0.2", zones: []string{"10.IN-ADDR.ARPA"}, zone: "10.in-addr.arpa", name: "2.0.0"},
+		{ip: "2001:db8::1", zone: "0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", name: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0"},
+		{ip: "not-an-ip", err: true},
+	}
+
+	for _, c := range cases {
+		zone, name, err := ReverseZone(c.ip, c.zones)
+		if c.err {
+			if err == nil {
+				t.Errorf("ReverseZone(%q, %v) returned no error", c.ip, c.zones)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ReverseZone(%q, %v): %v", c.ip, c.zones, err)
+			continue
+		}
+		if zone != c.zone || name != c.name {
+			t.Errorf("ReverseZone(%q, %v) = %q, %q, want %q, %q", c.ip, c.zones, zone, name, c.zone, c.name)
+		}
+	}
+}
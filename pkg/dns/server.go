@@ -0,0 +1,256 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultServerAddress = ":53"
+
+	forwardTimeout = 2 * time.Second
+	maxCnameChain  = 8
+)
+
+type serverZone struct {
+	locations map[string]*Record
+	serial    uint32
+}
+
+// Server is DNS server embedded into operator. It answers for zones written to it authoritatively
+// and forwards other queries to upstreams. Zones are kept in memory only, they're rebuilt from
+// container labels on start
+type Server struct {
+	upstreams []string
+
+	zones map[string]*serverZone
+	mutex sync.RWMutex
+
+	servers []*mdns.Server
+	log     *zap.Logger
+}
+
+// NewServer starts serving on address over UDP and TCP, upstreams without port are queried on 53
+func NewServer(log *zap.Logger, address string, upstreams []string) (*Server, error) {
+	if address == "" {
+		address = DefaultServerAddress
+	}
+
+	s := &Server{zones: map[string]*serverZone{}, log: log.Named("dns_server")}
+	for _, upstream := range upstreams {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(upstream, "53")
+		}
+		s.upstreams = append(s.upstreams, upstream)
+	}
+
+	packetConn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		packetConn.Close()
+		return nil, err
+	}
+
+	s.servers = []*mdns.Server{
+		{PacketConn: packetConn, Handler: s},
+		{Listener: listener, Handler: s},
+	}
+	for _, server := range s.servers {
+		go func(server *mdns.Server) {
+			if err := server.ActivateAndServe(); err != nil {
+				s.log.Error("DNS server stopped", zap.Error(err))
+			}
+		}(server)
+	}
+
+	s.log.Info("Serving DNS", zap.String("address", address), zap.Strings("upstreams", s.upstreams))
+	return s, nil
+}
+
+func (s *Server) Zones(ctx context.Context) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return sortedNames(s.zones), nil
+}
+
+func (s *Server) Get(ctx context.Context, zone string) (map[string]*Record, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	locations := map[string]*Record{}
+	if current, ok := s.zones[zone]; ok {
+		for name, record := range current.locations {
+			locations[name] = record
+		}
+	}
+	return locations, nil
+}
+
+func (s *Server) Put(ctx context.Context, zone string, locations map[string]*Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	serial := uint32(time.Now().Unix())
+	if current, ok := s.zones[zone]; ok && serial <= current.serial {
+		serial = current.serial + 1
+	}
+	s.zones[zone] = &serverZone{locations: locations, serial: serial}
+	return nil
+}
+
+func (s *Server) Close() error {
+	var result error
+	for _, server := range s.servers {
+		if err := server.Shutdown(); err != nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func (s *Server) ServeDNS(w mdns.ResponseWriter, r *mdns.Msg) {
+	if len(r.Question) != 1 {
+		m := new(mdns.Msg)
+		m.SetRcode(r, mdns.RcodeFormatError)
+		w.WriteMsg(m)
+		return
+	}
+
+	m, ok := s.answer(r)
+	if !ok {
+		s.forward(w, r)
+		return
+	}
+
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := mdns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		m.Truncate(size)
+	}
+	if err := w.WriteMsg(m); err != nil {
+		s.log.Debug("Fail to write response", zap.Error(err))
+	}
+}
+
+// answer resolves question from zone it belongs to, CNAME targets are followed within the zone.
+// Returns false if no served zone contains queried name
+func (s *Server) answer(r *mdns.Msg) (*mdns.Msg, bool) {
+	q := r.Question[0]
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	zoneName, zone := s.findZone(q.Name)
+	if zone == nil {
+		return nil, false
+	}
+
+	m := new(mdns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	soa := soaRR(zoneName, zone.serial)
+	name := relativeName(zoneName, q.Name)
+	if name == apex && q.Qtype == mdns.TypeSOA {
+		m.Answer = append(m.Answer, soa)
+		return m, true
+	}
+
+	for i := 0; i < maxCnameChain; i++ {
+		record, ok := zone.locations[name]
+		if !ok {
+			if i == 0 && name != apex && !hasDescendant(zone.locations, name) {
+				m.Rcode = mdns.RcodeNameError
+			}
+			break
+		}
+
+		var cname *mdns.CNAME
+		for _, rr := range recordRRs(zoneName, name, record) {
+			if rr.Header().Rrtype == q.Qtype || q.Qtype == mdns.TypeANY {
+				m.Answer = append(m.Answer, rr)
+			} else if rr, ok := rr.(*mdns.CNAME); ok {
+				cname = rr
+			}
+		}
+		if cname == nil {
+			break
+		}
+
+		m.Answer = append(m.Answer, cname)
+		if !mdns.IsSubDomain(mdns.Fqdn(zoneName), cname.Target) {
+			break
+		}
+		name = relativeName(zoneName, cname.Target)
+	}
+
+	if len(m.Answer) == 0 {
+		m.Ns = append(m.Ns, soa)
+	}
+	return m, true
+}
+
+// findZone picks the most specific served zone containing name
+func (s *Server) findZone(name string) (string, *serverZone) {
+	var found string
+	for zoneName := range s.zones {
+		if !mdns.IsSubDomain(strings.ToLower(mdns.Fqdn(zoneName)), strings.ToLower(name)) {
+			continue
+		}
+		if len(zoneName) > len(found) {
+			found = zoneName
+		}
+	}
+	if found == "" {
+		return "", nil
+	}
+	return found, s.zones[found]
+}
+
+// hasDescendant tells if name exists in zone only as parent of other names, so it's answered with no data
+func hasDescendant(locations map[string]*Record, name string) bool {
+	for location := range locations {
+		if strings.HasSuffix(location, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// forward passes query to upstreams in order, over the same transport it came
+func (s *Server) forward(w mdns.ResponseWriter, r *mdns.Msg) {
+	network := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		network = "tcp"
+	}
+	client := &mdns.Client{Net: network, Timeout: forwardTimeout}
+
+	for _, upstream := range s.upstreams {
+		response, _, err := client.Exchange(r, upstream)
+		if err != nil {
+			s.log.Debug("Upstream failed", zap.String("upstream", upstream), zap.Error(err))
+			continue
+		}
+		w.WriteMsg(response)
+		return
+	}
+
+	m := new(mdns.Msg)
+	rcode := mdns.RcodeServerFailure
+	if len(s.upstreams) == 0 {
+		rcode = mdns.RcodeRefused
+	}
+	m.SetRcode(r, rcode)
+	w.WriteMsg(m)
+}
@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/slntopp/nocloud-proto/dns"
+	"go.uber.org/zap"
+)
+
+// Desired is location container should publish under a name
+type Desired struct {
+	Location *Location
+	Owner    Owner
+}
+
+// Change is difference between desired and current location
+type Change struct {
+	Name   string
+	Before string
+	After  string
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Before == "":
+		return fmt.Sprintf("+ %s: %s", c.Name, c.After)
+	case c.After == "":
+		return fmt.Sprintf("- %s: %s", c.Name, c.Before)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Name, c.Before, c.After)
+}
+
+// Sync brings zone to desired state: desired locations are written, while other locations owned by
+// instance are deleted, unless kept. Zone is written only if anything changed. Names taken by records
+// instance doesn't own are returned as conflicts and left untouched
+func (d *DnsWrap) Sync(ctx context.Context, zoneName, instance string, desired map[string]Desired, keep map[string]struct{}) ([]Change, []string, error) {
+	log := d.log.Named("sync")
+
+	locations, err := d.Provider.Get(ctx, zoneName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var changes []Change
+	var conflicts []string
+	now := time.Now().UTC().String()
+
+	for _, name := range sortedNames(desired) {
+		want := desired[name]
+		if _, ok := locations[name]; ok && !takeable(locations, name, instance) {
+			conflicts = append(conflicts, name)
+			continue
+		}
+
+		before, after := locationString(locations, name), desiredString(want)
+		if before == after {
+			continue
+		}
+		changes = append(changes, Change{Name: name, Before: before, After: after})
+
+		ttl := want.Location.TTL.Of(mdns.TypeTXT)
+		marker := []*dns.Record_TXT{want.Owner.txt(ttl)}
+		audit := &dns.Record_TXT{Text: auditPrefix + now, Ttl: ttl}
+		delete(locations, auditName+name)
+		switch d.Audit {
+		case AuditOff:
+		case AuditSeparate:
+			locations[auditName+name] = &Record{Txt: []*dns.Record_TXT{audit}}
+		default:
+			marker = append(marker, audit)
+		}
+
+		record := want.Location.Record()
+		if want.Location.CNAME == "" {
+			record.Txt = append(record.Txt, marker...)
+			delete(locations, ownerPrefix+name)
+		} else {
+			locations[ownerPrefix+name] = &Record{Txt: marker}
+		}
+		locations[name] = record
+	}
+
+	owner := Owner{Instance: instance}
+	for _, name := range sortedNames(locations) {
+		_, wanted := desired[name]
+		_, kept := keep[name]
+		if wanted || kept || markerName(name) || !ownedBy(locations, name, owner) {
+			continue
+		}
+		changes = append(changes, Change{Name: name, Before: locationString(locations, name)})
+		deleteLocation(locations, name)
+	}
+
+	if len(changes) == 0 {
+		return nil, conflicts, nil
+	}
+
+	if err := d.Provider.Put(ctx, zoneName, locations); err != nil {
+		return nil, conflicts, err
+	}
+
+	diff := make([]string, len(changes))
+	for i, change := range changes {
+		diff[i] = change.String()
+	}
+	log.Info("Put DNS Records", zap.String("zone", zoneName), zap.Strings("changes", diff))
+	return changes, conflicts, nil
+}
+
+// locationString describes records of location and their owner, ignoring audit TXT
+func locationString(locations map[string]*Record, name string) string {
+	record, ok := locations[name]
+	if !ok {
+		return ""
+	}
+
+	var parts []string
+	for _, a := range record.A {
+		parts = append(parts, fmt.Sprintf("A %s ttl=%d", a.Ip, a.Ttl))
+	}
+	for _, aaaa := range record.Aaaa {
+		parts = append(parts, fmt.Sprintf("AAAA %s ttl=%d", aaaa.Ip, aaaa.Ttl))
+	}
+	for _, cname := range record.Cname {
+		parts = append(parts, fmt.Sprintf("CNAME %s ttl=%d", cname.Host, cname.Ttl))
+	}
+	for _, ptr := range record.Ptr {
+		parts = append(parts, fmt.Sprintf("PTR %s ttl=%d", ptr.Host, ptr.Ttl))
+	}
+	for _, srv := range record.Srv {
+		parts = append(parts, fmt.Sprintf("SRV %d %d %d %s ttl=%d", srv.Priority, srv.Weight, srv.Port, srv.Target, srv.Ttl))
+	}
+	for _, txt := range record.Txt {
+		if _, ok := parseOwner(txt.Text); ok || strings.HasPrefix(txt.Text, auditPrefix) {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("TXT %q ttl=%d", txt.Text, txt.Ttl))
+	}
+	if owner, ok := locationOwner(locations, name); ok && (owner.Instance != "" || owner.Container != "") {
+		parts = append(parts, fmt.Sprintf("owner %s/%s", owner.Instance, owner.Container))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func desiredString(desired Desired) string {
+	locations := map[string]*Record{}
+	record := desired.Location.Record()
+	if desired.Location.CNAME == "" {
+		record.Txt = append(record.Txt, desired.Owner.txt(defaultTtl))
+	} else {
+		locations[ownerPrefix+"name"] = &Record{Txt: []*dns.Record_TXT{desired.Owner.txt(defaultTtl)}}
+	}
+	locations["name"] = record
+	return locationString(locations, "name")
+}
+
+func sortedNames[T any](locations map[string]T) []string {
+	names := make([]string, 0, len(locations))
+	for name := range locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,204 @@
+package dns
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/slntopp/nocloud-proto/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	testZone     = "example.com"
+	testInstance = "nocloud-operator@host"
+)
+
+// countingProvider counts zone writes of FileProvider
+type countingProvider struct {
+	*FileProvider
+	puts int
+}
+
+func (p *countingProvider) Put(ctx context.Context, zone string, locations map[string]*Record) error {
+	p.puts++
+	return p.FileProvider.Put(ctx, zone, locations)
+}
+
+func desiredA(ip string) map[string]Desired {
+	return map[string]Desired{
+		"web": {Location: &Location{A: []string{ip}}, Owner: Owner{Instance: testInstance, Container: "web"}},
+	}
+}
+
+func TestSync(t *testing.T) {
+	cases := []struct {
+		name string
+		// existing is written to zone before sync, previous is synced by instance before it
+		existing  map[string]*Record
+		previous  map[string]Desired
+		desired   map[string]Desired
+		changes   []string
+		conflicts []string
+		puts      int
+		web       string
+	}{
+		{
+			name:    "create",
+			desired: desiredA("10.0.0.2"),
+			changes: []string{"web"},
+			puts:    1,
+			web:     "A 10.0.0.2 ttl=300, owner " + testInstance + "/web",
+		},
+		{
+			name:     "repeat sync doesn't write",
+			previous: desiredA("10.0.0.2"),
+			desired:  desiredA("10.0.0.2"),
+			web:      "A 10.0.0.2 ttl=300, owner " + testInstance + "/web",
+		},
+		{
+			name:     "update",
+			previous: desiredA("10.0.0.2"),
+			desired:  desiredA("10.0.0.3"),
+			changes:  []string{"web"},
+			puts:     1,
+			web:      "A 10.0.0.3 ttl=300, owner " + testInstance + "/web",
+		},
+		{
+			name:     "delete no longer desired",
+			previous: desiredA("10.0.0.2"),
+			desired:  map[string]Desired{},
+			changes:  []string{"web"},
+			puts:     1,
+		},
+		{
+			name: "conflict for unowned name",
+			existing: map[string]*Record{
+				"web": {A: []*dns.Record_A{{Ip: "10.0.0.9", Ttl: 60}}},
+			},
+			desired:   desiredA("10.0.0.2"),
+			conflicts: []string{"web"},
+			web:       "A 10.0.0.9 ttl=60",
+		},
+		{
+			name: "conflict for name of other instance",
+			existing: map[string]*Record{
+				"web": {
+					A:   []*dns.Record_A{{Ip: "10.0.0.9", Ttl: 60}},
+					Txt: []*dns.Record_TXT{Owner{Instance: "nocloud-operator@other", Container: "web"}.txt(60)},
+				},
+			},
+			desired:   desiredA("10.0.0.2"),
+			conflicts: []string{"web"},
+			web:       "A 10.0.0.9 ttl=60, owner nocloud-operator@other/web",
+		},
+		{
+			name: "takeover of legacy audit record",
+			existing: map[string]*Record{
+				"web": {
+					A:   []*dns.Record_A{{Ip: "10.0.0.9", Ttl: 60}},
+					Txt: []*dns.Record_TXT{{Text: auditPrefix + "2023-01-01 00:00:00 +0000 UTC", Ttl: 60}},
+				},
+			},
+			desired: desiredA("10.0.0.2"),
+			changes: []string{"web"},
+			puts:    1,
+			web:     "A 10.0.0.2 ttl=300, owner " + testInstance + "/web",
+		},
+		{
+			name: "takeover of identical record without owner instance",
+			existing: map[string]*Record{
+				"web": {
+					A:   []*dns.Record_A{{Ip: "10.0.0.2", Ttl: 300}},
+					Txt: []*dns.Record_TXT{Owner{Container: "web"}.txt(300)},
+				},
+			},
+			desired: desiredA("10.0.0.2"),
+			changes: []string{"web"},
+			puts:    1,
+			web:     "A 10.0.0.2 ttl=300, owner " + testInstance + "/web",
+		},
+		{
+			name: "legacy record isn't deleted",
+			existing: map[string]*Record{
+				"web": {
+					A:   []*dns.Record_A{{Ip: "10.0.0.9", Ttl: 60}},
+					Txt: []*dns.Record_TXT{{Text: auditPrefix + "2023-01-01 00:00:00 +0000 UTC", Ttl: 60}},
+				},
+			},
+			desired: map[string]Desired{},
+			web:     "A 10.0.0.9 ttl=60",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			files, err := NewFileProvider(t.TempDir(), FormatZone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			provider := &countingProvider{FileProvider: files}
+			wrap := NewDnsWrap(zap.NewNop(), "", "", provider)
+
+			if c.existing != nil {
+				if err := files.Put(ctx, testZone, c.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if c.previous != nil {
+				if _, _, err := wrap.Sync(ctx, testZone, testInstance, c.previous, nil); err != nil {
+					t.Fatal(err)
+				}
+				provider.puts = 0
+			}
+
+			changes, conflicts, err := wrap.Sync(ctx, testZone, testInstance, c.desired, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, change := range changes {
+				names = append(names, change.Name)
+			}
+			if !reflect.DeepEqual(names, c.changes) {
+				t.Errorf("changes = %v, want %v", names, c.changes)
+			}
+			if !reflect.DeepEqual(conflicts, c.conflicts) {
+				t.Errorf("conflicts = %v, want %v", conflicts, c.conflicts)
+			}
+			if provider.puts != c.puts {
+				t.Errorf("puts = %d, want %d", provider.puts, c.puts)
+			}
+
+			locations, err := files.Get(ctx, testZone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if web := locationString(locations, "web"); web != c.web {
+				t.Errorf("web = %q, want %q", web, c.web)
+			}
+		})
+	}
+}
+
+func TestSyncKeepsOwnedLocation(t *testing.T) {
+	ctx := context.Background()
+	files, err := NewFileProvider(t.TempDir(), FormatZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrap := NewDnsWrap(zap.NewNop(), "", "", files)
+
+	if _, _, err := wrap.Sync(ctx, testZone, testInstance, desiredA("10.0.0.2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	changes, _, err := wrap.Sync(ctx, testZone, testInstance, map[string]Desired{}, map[string]struct{}{"web": {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("changes = %v, want none", changes)
+	}
+}
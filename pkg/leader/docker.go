@@ -0,0 +1,106 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	dockerFilters "github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	dockerClient "github.com/docker/docker/client"
+)
+
+const (
+	lockLabel   = "nocloud.leader.lock"
+	holderLabel = "nocloud.leader.holder"
+)
+
+// DockerLock keeps lease in labels of empty Docker volumes. Time is split into slots of ttl, every slot
+// has its own volume named <name>-<slot>. Creation of volume with taken name returns the existing one,
+// so whoever creates slot volume first holds the slot and volumes are never replaced. Holder takes
+// the next slot in advance, others may take only the current one, so lease passes to another
+// instance at most two slots after holder stops renewing it
+type DockerLock struct {
+	client *dockerClient.Client
+	name   string
+}
+
+func NewDockerLock(client *dockerClient.Client, name string) *DockerLock {
+	return &DockerLock{client: client, name: name}
+}
+
+func (l *DockerLock) TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error) {
+	slot := currentSlot(ttl)
+
+	holder, err := l.take(ctx, slot, identity)
+	if err != nil || holder != identity {
+		return false, err
+	}
+
+	if _, err := l.take(ctx, slot+1, identity); err != nil {
+		return false, err
+	}
+	l.removeExpired(ctx, slot)
+	return true, nil
+}
+
+func (l *DockerLock) Release(ctx context.Context, identity string) error {
+	filters := dockerFilters.NewArgs()
+	filters.Add("label", lockLabel+"="+l.name)
+	filters.Add("label", holderLabel+"="+identity)
+	list, err := l.client.VolumeList(ctx, volume.ListOptions{Filters: filters})
+	if err != nil {
+		return err
+	}
+	for _, item := range list.Volumes {
+		if err := l.client.VolumeRemove(ctx, item.Name, true); err != nil && !dockerClient.IsErrNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// take creates volume of slot for identity, returning holder of the slot
+func (l *DockerLock) take(ctx context.Context, slot int64, identity string) (string, error) {
+	created, err := l.client.VolumeCreate(ctx, volume.CreateOptions{
+		Name: l.slotName(slot),
+		Labels: map[string]string{
+			lockLabel:   l.name,
+			holderLabel: identity,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return created.Labels[holderLabel], nil
+}
+
+// removeExpired removes volumes of slots before the current one, nobody relies on them anymore
+func (l *DockerLock) removeExpired(ctx context.Context, current int64) {
+	filters := dockerFilters.NewArgs()
+	filters.Add("label", lockLabel+"="+l.name)
+	list, err := l.client.VolumeList(ctx, volume.ListOptions{Filters: filters})
+	if err != nil {
+		return
+	}
+	for _, item := range list.Volumes {
+		slot, err := strconv.ParseInt(strings.TrimPrefix(item.Name, l.name+"-"), 10, 64)
+		if err != nil || slot >= current {
+			continue
+		}
+		l.client.VolumeRemove(ctx, item.Name, true)
+	}
+}
+
+func (l *DockerLock) slotName(slot int64) string {
+	return fmt.Sprintf("%s-%d", l.name, slot)
+}
+
+func currentSlot(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+	return time.Now().UnixNano() / int64(ttl)
+}
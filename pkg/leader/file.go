@@ -0,0 +1,90 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+type lease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// FileLock keeps lease in a file on volume shared by operator instances,
+// file is flock'ed while lease is read and updated
+type FileLock struct {
+	path string
+}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path}
+}
+
+func (l *FileLock) TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error) {
+	acquired := false
+	err := l.update(func(current *lease) bool {
+		if current.Holder != "" && current.Holder != identity && time.Now().Before(current.Expires) {
+			return false
+		}
+		current.Holder, current.Expires = identity, time.Now().Add(ttl)
+		acquired = true
+		return true
+	})
+	return acquired, err
+}
+
+func (l *FileLock) Release(ctx context.Context, identity string) error {
+	return l.update(func(current *lease) bool {
+		if current.Holder != identity {
+			return false
+		}
+		*current = lease{}
+		return true
+	})
+}
+
+// update runs fn on current lease under exclusive lock, writing lease back if fn returns true
+func (l *FileLock) update(fn func(current *lease) bool) error {
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	var current lease
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &current); err != nil {
+			return err
+		}
+	}
+
+	if !fn(&current) {
+		return nil
+	}
+
+	data, err = json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,127 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Lock is a lease storage operator instances compete for
+type Lock interface {
+	// TryAcquire takes lease for identity or renews it if identity already holds it.
+	// Returns true if identity holds the lease afterwards
+	TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error)
+	// Release gives lease up if identity holds it
+	Release(ctx context.Context, identity string) error
+}
+
+type Elector struct {
+	Identity string
+
+	lock    Lock
+	ttl     time.Duration
+	leading atomic.Bool
+
+	// term is closed once current leadership ends
+	term      chan struct{}
+	termMutex sync.Mutex
+
+	log *zap.Logger
+}
+
+func NewElector(log *zap.Logger, lock Lock, identity string, ttl time.Duration) *Elector {
+	term := make(chan struct{})
+	close(term)
+	return &Elector{Identity: identity, lock: lock, ttl: ttl, term: term, log: log.Named("Leader")}
+}
+
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Term returns channel closed once current leadership ends, it's closed already if instance isn't leader
+func (e *Elector) Term() <-chan struct{} {
+	e.termMutex.Lock()
+	defer e.termMutex.Unlock()
+	return e.term
+}
+
+// setLeading switches leadership, starting new term or ending current one. Returns previous state
+func (e *Elector) setLeading(leading bool) bool {
+	e.termMutex.Lock()
+	defer e.termMutex.Unlock()
+
+	was := e.leading.Swap(leading)
+	switch {
+	case leading && !was:
+		e.term = make(chan struct{})
+	case !leading && was:
+		close(e.term)
+	}
+	return was
+}
+
+// Run keeps trying to acquire and renew the lease until ctx is cancelled, then releases it
+func (e *Elector) Run(ctx context.Context) {
+	log := e.log.Named("run")
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		e.tryAcquire(ctx)
+
+		select {
+		case <-ctx.Done():
+			if e.setLeading(false) {
+				releaseCtx, cancel := context.WithTimeout(context.Background(), e.ttl)
+				if err := e.lock.Release(releaseCtx, e.Identity); err != nil {
+					log.Warn("Fail to release lease", zap.Error(err))
+				}
+				cancel()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Await blocks until instance becomes leader, returns false if ctx is cancelled first
+func (e *Elector) Await(ctx context.Context) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for !e.IsLeader() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+func (e *Elector) tryAcquire(ctx context.Context) {
+	log := e.log.Named("acquire")
+
+	acquired, err := e.lock.TryAcquire(ctx, e.Identity, e.ttl)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		// Lease can't be confirmed, so it must be treated as lost before it expires for others
+		log.Error("Fail to acquire lease", zap.Error(err))
+		acquired = false
+	}
+
+	if was := e.setLeading(acquired); was != acquired {
+		if acquired {
+			log.Info("Became leader", zap.String("identity", e.Identity))
+		} else {
+			log.Warn("Lost leadership", zap.String("identity", e.Identity))
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package leader
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	acquireScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder == false or holder == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+)
+
+// RedisLock keeps lease as Redis key holding leader identity with expiration
+type RedisLock struct {
+	client *redis.Client
+	key    string
+}
+
+func NewRedisLock(client *redis.Client, key string) *RedisLock {
+	return &RedisLock{client: client, key: key}
+}
+
+func (l *RedisLock) TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error) {
+	result, err := acquireScript.Run(ctx, l.client, []string{l.key}, identity, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return result == 1, nil
+}
+
+func (l *RedisLock) Release(ctx context.Context, identity string) error {
+	return releaseScript.Run(ctx, l.client, []string{l.key}, identity).Err()
+}
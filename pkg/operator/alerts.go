@@ -0,0 +1,71 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// alertTimeout bounds webhook request, so slow webhook doesn't pile up alerts
+const alertTimeout = 10 * time.Second
+
+var alertClient = &http.Client{Timeout: alertTimeout}
+
+type Alert struct {
+	ContainerId string    `json:"container_id"`
+	Name        string    `json:"name"`
+	Reason      string    `json:"reason"`
+	Logs        string    `json:"logs,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// alert always logs the alert and posts it to webhook if one is configured. Webhook is called in background,
+// so callers holding locks or running event loop don't wait for it
+func (o *Operator) alert(ctx context.Context, alert Alert) {
+	log := o.log.Named("alert")
+	alert.Time = time.Now().UTC()
+
+	log.Error("Alert", zap.String("id", alert.ContainerId), zap.String("name", alert.Name), zap.String("reason", alert.Reason))
+
+	if o.config.Alerts.Webhook == "" {
+		return
+	}
+	go o.postAlert(alert)
+}
+
+func (o *Operator) postAlert(alert Alert) {
+	log := o.log.Named("alert")
+
+	// Alert raised on shutdown is still sent, so request isn't bound to operator context
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(alert)
+	if err != nil {
+		log.Error("Error marshal alert", zap.Error(err))
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.config.Alerts.Webhook, bytes.NewReader(body))
+	if err != nil {
+		log.Error("Error creating alert request", zap.Error(err))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		log.Error("Error sending alert", zap.Error(err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Error("Error sending alert", zap.Error(fmt.Errorf("webhook responded with %s", resp.Status)))
+	}
+}
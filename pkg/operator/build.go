@@ -0,0 +1,336 @@
+package operator
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/slntopp/nocloud-operator/pkg/dns"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+const buildHashLabel = "nocloud.build.hash"
+
+// ServiceBuild is compose build section, either context path or mapping
+type ServiceBuild struct {
+	Context    string    `yaml:"context"`
+	Dockerfile string    `yaml:"dockerfile"`
+	Target     string    `yaml:"target"`
+	Args       BuildArgs `yaml:"args"`
+}
+
+func (b *ServiceBuild) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		b.Context = value.Value
+		return nil
+	}
+
+	type plain ServiceBuild
+	return value.Decode((*plain)(b))
+}
+
+// BuildArgs are compose build args given either as mapping or as list of KEY=VALUE
+type BuildArgs map[string]string
+
+func (a *BuildArgs) UnmarshalYAML(value *yaml.Node) error {
+	*a = BuildArgs{}
+	if value.Kind == yaml.SequenceNode {
+		var list []string
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+		for _, item := range list {
+			key, arg, _ := strings.Cut(item, "=")
+			(*a)[key] = arg
+		}
+		return nil
+	}
+
+	var args map[string]string
+	if err := value.Decode(&args); err != nil {
+		return err
+	}
+	for key, arg := range args {
+		(*a)[key] = arg
+	}
+	return nil
+}
+
+// serviceImage returns image service runs, services built without image set are tagged as compose does
+func (o *Operator) serviceImage(name string, service *Service) string {
+	if service.Image != "" {
+		return service.Image
+	}
+	return o.config.ComposePrefix + name
+}
+
+// checkBuilds rebuilds images of services labeled with nocloud.build.watch once their
+// build context changes and rolls containers onto new images
+func (o *Operator) checkBuilds(ctx context.Context) {
+	log := o.log.Named("check_builds")
+
+	composeConfig := readComposeConfig("./docker-compose.yml", log)
+	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		log.Error("Error to get containers", zap.Error(err))
+		return
+	}
+
+	for _, item := range containersList {
+		if _, ok := item.Labels[dns.BuildWatchLabel]; !ok || len(item.Names) == 0 {
+			continue
+		}
+
+		name, service := findComposeService(composeConfig, item.Labels, item.Names[0])
+		if service == nil || service.Build.Context == "" {
+			continue
+		}
+
+		imageName := o.serviceImage(name, service)
+		hash, err := buildContextHash(service.Build)
+		if err != nil {
+			log.Error("Error hashing build context", zap.String("service", name), zap.Error(err))
+			continue
+		}
+
+		image, _, err := o.client.ImageInspectWithRaw(ctx, imageName)
+		if err == nil && image.Config != nil && image.Config.Labels[buildHashLabel] == hash {
+			continue
+		}
+
+		container, _, err := o.client.ContainerInspectWithRaw(ctx, item.ID, false)
+		if err != nil {
+			log.Error("Error to inspect container", zap.String("id", item.ID), zap.Error(err))
+			continue
+		}
+
+		log.Info("Build context changed", zap.String("service", name), zap.String("hash", hash))
+		if err := o.buildImage(ctx, imageName, service.Build); err != nil {
+			log.Error("Error building image", zap.String("service", name), zap.Error(err))
+			continue
+		}
+
+		endpointsConfig := NewEndpointsConfig(container.HostConfig.NetworkMode, container.NetworkSettings.Networks, container.ID)
+		o.updateImageAndContainer(ctx, imageName, container.Image, container.ID, container.Name, container.HostConfig, container.Config.Labels, endpointsConfig)
+	}
+}
+
+// buildImage builds and tags image from compose build section, context is resolved
+// relative to operator working dir, same as docker-compose.yml
+func (o *Operator) buildImage(ctx context.Context, imageName string, build ServiceBuild) error {
+	log := o.log.Named("build_image")
+
+	hash, err := buildContextHash(build)
+	if err != nil {
+		return err
+	}
+
+	buildContext, err := tarBuildContext(build)
+	if err != nil {
+		return err
+	}
+	defer buildContext.Close()
+
+	args := make(map[string]*string, len(build.Args))
+	for key, value := range build.Args {
+		value := getEnvValue(value)
+		args[key] = &value
+	}
+
+	log.Info("Building image", zap.String("image", imageName), zap.String("context", build.Context))
+	defer o.ownImage(imageName)()
+	resp, err := o.client.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
+		Tags:       []string{imageName},
+		Dockerfile: build.dockerfile(),
+		BuildArgs:  args,
+		Target:     build.Target,
+		Labels:     map[string]string{buildHashLabel: hash},
+		Remove:     true,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return jsonmessage.DisplayJSONMessagesStream(resp.Body, os.Stdout, 0, false, nil)
+}
+
+func (b ServiceBuild) dockerfile() string {
+	if b.Dockerfile == "" {
+		return "Dockerfile"
+	}
+	return filepath.ToSlash(b.Dockerfile)
+}
+
+// buildContextFiles lists files of build context not excluded by .dockerignore in stable order
+func buildContextFiles(build ServiceBuild) ([]string, error) {
+	var patterns []string
+	ignore, err := os.Open(filepath.Join(build.Context, ".dockerignore"))
+	if err == nil {
+		patterns, err = ignorefile.ReadAll(ignore)
+		ignore.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	matcher, err := patternmatcher.New(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0)
+	err = filepath.Walk(build.Context, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(build.Context, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		// Dockerfile and .dockerignore are always sent, Docker needs them even if ignored
+		if rel != build.dockerfile() && rel != ".dockerignore" {
+			excluded, err := matcher.MatchesOrParentMatches(rel)
+			if err != nil {
+				return err
+			}
+			if excluded {
+				if info.IsDir() && !matcher.Exclusions() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if info.Mode().IsRegular() || info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+func buildContextHash(build ServiceBuild) (string, error) {
+	files, err := buildContextFiles(build)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	keys := make([]string, 0, len(build.Args))
+	for key := range build.Args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(hash, "arg:%s=%s\n", key, getEnvValue(build.Args[key]))
+	}
+	fmt.Fprintf(hash, "target:%s\ndockerfile:%s\n", build.Target, build.dockerfile())
+
+	for _, rel := range files {
+		path := filepath.Join(build.Context, filepath.FromSlash(rel))
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s %o\n", rel, info.Mode())
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(hash, "-> %s\n", link)
+			continue
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// tarBuildContext streams build context as tar, so it isn't kept in memory. Symlinks are sent as links,
+// same as docker compose does
+func tarBuildContext(build ServiceBuild) (io.ReadCloser, error) {
+	files, err := buildContextFiles(build)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeBuildContext(writer, build.Context, files))
+	}()
+	return reader, nil
+}
+
+func writeBuildContext(w io.Writer, dir string, files []string) error {
+	writer := tar.NewWriter(w)
+	for _, rel := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(writer, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
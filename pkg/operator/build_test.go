@@ -0,0 +1,93 @@
+package operator
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTarBuildContext(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Dockerfile":    "FROM scratch\nCOPY . /\n",
+		".dockerignore": "*.log\n",
+		"app/main.go":   "package main\n",
+		"debug.log":     "ignored\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("app/main.go", filepath.Join(dir, "main.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	build := ServiceBuild{Context: dir}
+	stream, err := tarBuildContext(build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	entries := map[string]string{}
+	reader := tar.NewReader(stream)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			entries[header.Name] = "-> " + header.Linkname
+		case tar.TypeDir:
+			entries[header.Name] = "dir"
+		default:
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries[header.Name] = string(content)
+		}
+	}
+
+	want := map[string]string{
+		".dockerignore": files[".dockerignore"],
+		"Dockerfile":    files["Dockerfile"],
+		"app":           "dir",
+		"app/main.go":   files["app/main.go"],
+		"main.go":       "-> app/main.go",
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %v, want %v", entries, want)
+	}
+
+	// Changing where link points changes context hash
+	before, err := buildContextHash(build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "main.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("Dockerfile", filepath.Join(dir, "main.go")); err != nil {
+		t.Fatal(err)
+	}
+	after, err := buildContextHash(build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("hash didn't change with symlink target")
+	}
+}
@@ -0,0 +1,136 @@
+package operator
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	dockerContainer "github.com/docker/docker/api/types/container"
+)
+
+const (
+	composeServiceLabel    = "com.docker.compose.service"
+	composeWorkingDirLabel = "com.docker.compose.project.working_dir"
+)
+
+// findComposeService returns compose service container was created from, matching
+// it by compose service label first and by container name otherwise
+func findComposeService(config Config, labels map[string]string, containerName string) (string, *Service) {
+	if name, ok := labels[composeServiceLabel]; ok {
+		if service, ok := config.Services[name]; ok {
+			return name, &service
+		}
+	}
+
+	containerName = strings.TrimPrefix(containerName, "/")
+	for name, service := range config.Services {
+		if service.ContainerName != "" && service.ContainerName == containerName {
+			return name, &service
+		}
+	}
+	return "", nil
+}
+
+func composeLabels(service *Service) map[string]string {
+	labels := make(map[string]string, len(service.Labels))
+	for _, label := range service.Labels {
+		key, value, _ := strings.Cut(label, "=")
+		labels[key] = value
+	}
+	return labels
+}
+
+func (o *Operator) composeNetworks(service *Service) map[string]struct{} {
+	networks := make(map[string]struct{}, len(service.Networks))
+	for key := range service.Networks {
+		networks[o.config.ComposePrefix+key] = struct{}{}
+	}
+	if len(networks) == 0 {
+		networks[o.config.ComposePrefix+"default"] = struct{}{}
+	}
+	return networks
+}
+
+// composeMount is a compose volume entry resolved to what Docker would mount
+type composeMount struct {
+	Source      string
+	Destination string
+	Mode        string
+	// Bind is set for host paths, otherwise Source is a named volume (or empty for anonymous one)
+	Bind bool
+}
+
+// composeMounts resolves compose volumes, relative host paths are resolved against
+// compose project working dir, named volumes get compose prefix unless external
+func (o *Operator) composeMounts(config Config, service *Service, workingDir string) []composeMount {
+	mounts := make([]composeMount, 0, len(service.Volumes))
+	for _, volume := range service.Volumes {
+		parts := strings.Split(volume, ":")
+		mount := composeMount{}
+
+		switch len(parts) {
+		case 1:
+			mount.Destination = parts[0]
+			mounts = append(mounts, mount)
+			continue
+		case 2:
+			mount.Source, mount.Destination = parts[0], parts[1]
+		default:
+			mount.Source, mount.Destination, mount.Mode = parts[0], parts[1], parts[2]
+		}
+
+		switch {
+		case strings.HasPrefix(mount.Source, "/"):
+			mount.Bind = true
+		case strings.HasPrefix(mount.Source, ".") || strings.HasPrefix(mount.Source, "~"):
+			mount.Bind = true
+			if workingDir != "" && !strings.HasPrefix(mount.Source, "~") {
+				mount.Source = path.Join(workingDir, mount.Source)
+			}
+		default:
+			if volume, ok := config.Volumes[mount.Source]; !ok || volume.External == "" || volume.External == "false" {
+				mount.Source = o.config.ComposePrefix + mount.Source
+			}
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts
+}
+
+func composeBinds(mounts []composeMount) []string {
+	binds := make([]string, 0, len(mounts))
+	for _, mount := range mounts {
+		if mount.Source == "" {
+			continue
+		}
+		bind := mount.Source + ":" + mount.Destination
+		if mount.Mode != "" {
+			bind += ":" + mount.Mode
+		}
+		binds = append(binds, bind)
+	}
+	return binds
+}
+
+// composeVolumes lists container paths of anonymous volumes, others are given as binds
+func composeVolumes(mounts []composeMount) map[string]struct{} {
+	volumes := make(map[string]struct{})
+	for _, mount := range mounts {
+		if mount.Source == "" {
+			volumes[mount.Destination] = struct{}{}
+		}
+	}
+	return volumes
+}
+
+func composeRestartPolicy(service *Service) dockerContainer.RestartPolicy {
+	name, count, _ := strings.Cut(service.Restart, ":")
+	if name == "" {
+		name = "no"
+	}
+	policy := dockerContainer.RestartPolicy{Name: name}
+	if count != "" {
+		policy.MaximumRetryCount, _ = strconv.Atoi(count)
+	}
+	return policy
+}
@@ -26,12 +26,12 @@ type Service struct {
 	Links         []string               `yaml:"links"`
 	Labels        []string               `yaml:"labels"`
 	Volumes       []string               `yaml:"volumes"`
-	Ports         []string               `yaml:"ports"`
+	Ports         []ServicePort          `yaml:"ports"`
 	Environment   map[string]string      `yaml:"environment"`
 	Networks      map[string]interface{} `yaml:"networks"`
 	Command       string                 `yaml:"command"`
 	VolumesFrom   []string               `yaml:"volumes_from"`
 	DependsOn     []string               `yaml:"depends_on"`
 	CapAdd        []string               `yaml:"cap_add"`
-	Build         struct{ Context, Dockerfile string }
+	Build         ServiceBuild           `yaml:"build"`
 }
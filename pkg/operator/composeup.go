@@ -0,0 +1,312 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	dockerContainer "github.com/docker/docker/api/types/container"
+	dockerFilters "github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	dockerClient "github.com/docker/docker/client"
+	"go.uber.org/zap"
+)
+
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeNetworkLabel = "com.docker.compose.network"
+	composeVolumeLabel  = "com.docker.compose.volume"
+	composeNumberLabel  = "com.docker.compose.container-number"
+	composeOneoffLabel  = "com.docker.compose.oneoff"
+)
+
+func (o *Operator) composeProject() string {
+	return strings.TrimSuffix(o.config.ComposePrefix, "_")
+}
+
+func (o *Operator) serviceContainerName(name string, service *Service) string {
+	if service.ContainerName != "" {
+		return service.ContainerName
+	}
+	return fmt.Sprintf("%s%s_1", o.config.ComposePrefix, name)
+}
+
+// UpComposeServices creates networks, volumes and services declared in compose file but missing on host.
+// Services are created in depends_on order, existing containers are left as they are even if stopped
+func (o *Operator) UpComposeServices(ctx context.Context) {
+	log := o.log.Named("up_compose_services")
+
+	composeConfig := readComposeConfig("./docker-compose.yml", log)
+	if len(composeConfig.Services) == 0 {
+		return
+	}
+
+	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		log.Error("Error to get containers", zap.Error(err))
+		return
+	}
+
+	existing := map[string]struct{}{}
+	workingDir := ""
+	for _, container := range containersList {
+		name := ""
+		if len(container.Names) != 0 {
+			name = container.Names[0]
+		}
+		if serviceName, service := findComposeService(composeConfig, container.Labels, name); service != nil {
+			existing[serviceName] = struct{}{}
+		}
+		if dir, ok := container.Labels[composeWorkingDirLabel]; ok && container.Labels[composeProjectLabel] == o.composeProject() {
+			workingDir = dir
+		}
+	}
+
+	order, err := servicesOrder(composeConfig.Services)
+	if err != nil {
+		log.Error("Error resolving services order", zap.Error(err))
+		return
+	}
+
+	missing := make([]string, 0)
+	for _, name := range order {
+		if _, ok := existing[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	log.Info("Found missing services", zap.Strings("services", missing))
+
+	if err := o.upComposeNetworks(ctx, composeConfig); err != nil {
+		log.Error("Error creating networks", zap.Error(err))
+		return
+	}
+	if err := o.upComposeVolumes(ctx, composeConfig); err != nil {
+		log.Error("Error creating volumes", zap.Error(err))
+		return
+	}
+
+	for _, name := range missing {
+		service := composeConfig.Services[name]
+		log.Info("Creating missing service", zap.String("service", name))
+		if err := o.upComposeService(ctx, composeConfig, name, &service, workingDir); err != nil {
+			log.Error("Error creating service", zap.String("service", name), zap.Error(err))
+		}
+	}
+}
+
+func (o *Operator) upComposeNetworks(ctx context.Context, config Config) error {
+	log := o.log.Named("up_compose_networks")
+
+	networks := map[string]Network{}
+	for key, value := range config.Networks {
+		networks[key] = value
+	}
+	for _, service := range config.Services {
+		if len(service.Networks) == 0 {
+			if _, ok := networks["default"]; !ok {
+				networks["default"] = Network{}
+			}
+		}
+	}
+
+	for key, value := range networks {
+		if value.External != "" && value.External != "false" {
+			continue
+		}
+		name := o.config.ComposePrefix + key
+
+		filters := dockerFilters.NewArgs()
+		filters.Add("name", name)
+		list, err := o.client.NetworkList(ctx, types.NetworkListOptions{Filters: filters})
+		if err != nil {
+			return err
+		}
+		exists := false
+		for _, item := range list {
+			if item.Name == name {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+
+		log.Info("Creating network", zap.String("name", name), zap.String("driver", value.Driver))
+		_, err = o.client.NetworkCreate(ctx, name, types.NetworkCreate{
+			CheckDuplicate: true,
+			Driver:         value.Driver,
+			Options:        value.DriverOpts,
+			Labels: map[string]string{
+				composeProjectLabel: o.composeProject(),
+				composeNetworkLabel: key,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("creating network %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (o *Operator) upComposeVolumes(ctx context.Context, config Config) error {
+	log := o.log.Named("up_compose_volumes")
+
+	for key, value := range config.Volumes {
+		if value.External != "" && value.External != "false" {
+			continue
+		}
+		name := o.config.ComposePrefix + key
+
+		_, err := o.client.VolumeInspect(ctx, name)
+		if err == nil {
+			continue
+		}
+		if !dockerClient.IsErrNotFound(err) {
+			return err
+		}
+
+		log.Info("Creating volume", zap.String("name", name), zap.String("driver", value.Driver))
+		_, err = o.client.VolumeCreate(ctx, volume.CreateOptions{
+			Name:       name,
+			Driver:     value.Driver,
+			DriverOpts: value.DriverOpts,
+			Labels: map[string]string{
+				composeProjectLabel: o.composeProject(),
+				composeVolumeLabel:  key,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("creating volume %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (o *Operator) upComposeService(ctx context.Context, config Config, name string, service *Service, workingDir string) error {
+	imageName := o.serviceImage(name, service)
+	if _, _, err := o.client.ImageInspectWithRaw(ctx, imageName); err != nil {
+		if service.Build.Context != "" {
+			if err := o.buildImage(ctx, imageName, service.Build); err != nil {
+				return err
+			}
+		} else {
+			o.pullImage(ctx, imageName)
+		}
+	}
+
+	containerConfig, portBindings, networksNames := o.getServiceContainerConfig(name, service)
+
+	labels := composeLabels(service)
+	labels[composeProjectLabel] = o.composeProject()
+	labels[composeServiceLabel] = name
+	labels[composeNumberLabel] = "1"
+	labels[composeOneoffLabel] = "False"
+	if workingDir != "" {
+		labels[composeWorkingDirLabel] = workingDir
+	}
+
+	hostCfg := &dockerContainer.HostConfig{
+		RestartPolicy: composeRestartPolicy(service),
+		Binds:         composeBinds(o.composeMounts(config, service, workingDir)),
+		CapAdd:        service.CapAdd,
+		PortBindings:  portBindings,
+	}
+
+	if err := o.checkPortConflicts(ctx, "", portBindings); err != nil {
+		return err
+	}
+
+	for _, link := range service.Links {
+		target, alias, ok := strings.Cut(link, ":")
+		if !ok {
+			alias = target
+		}
+		if targetService, ok := config.Services[target]; ok {
+			target = o.serviceContainerName(target, &targetService)
+		}
+		hostCfg.Links = append(hostCfg.Links, target+":"+alias)
+	}
+
+	for _, from := range service.VolumesFrom {
+		source, mode, _ := strings.Cut(from, ":")
+		if sourceService, ok := config.Services[source]; ok {
+			source = o.serviceContainerName(source, &sourceService)
+		}
+		if mode != "" {
+			source += ":" + mode
+		}
+		hostCfg.VolumesFrom = append(hostCfg.VolumesFrom, source)
+	}
+
+	endpointsConfig := &EndpointsConfig{Networks: map[string]*network.EndpointSettings{}}
+	networkNames := make([]string, 0, len(*networksNames))
+	for networkName := range *networksNames {
+		networkNames = append(networkNames, networkName)
+	}
+	sort.Strings(networkNames)
+	for _, networkName := range networkNames {
+		endpointsConfig.Add(networkName)
+		endpointsConfig.Networks[networkName].Aliases = []string{name}
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.createContainer(ctx, containerConfig, networksNames, hostCfg, o.serviceContainerName(name, service), &labels, endpointsConfig)
+}
+
+// servicesOrder sorts services so every service goes after services it depends on
+func servicesOrder(services map[string]Service) ([]string, error) {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	order := make([]string, 0, len(services))
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle at service %s", name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+
+		dependencies := append([]string{}, services[name].DependsOn...)
+		sort.Strings(dependencies)
+		for _, dependency := range dependencies {
+			if _, ok := services[dependency]; !ok {
+				return fmt.Errorf("service %s depends on undefined service %s", name, dependency)
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+
+		state[name] = visited
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
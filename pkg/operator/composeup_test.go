@@ -0,0 +1,61 @@
+package operator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServicesOrder(t *testing.T) {
+	cases := []struct {
+		name     string
+		services map[string]Service
+		order    []string
+		err      bool
+	}{
+		{
+			name:     "independent services sorted by name",
+			services: map[string]Service{"web": {}, "api": {}, "db": {}},
+			order:    []string{"api", "db", "web"},
+		},
+		{
+			name: "dependencies go first",
+			services: map[string]Service{
+				"web":   {DependsOn: []string{"api"}},
+				"api":   {DependsOn: []string{"db", "cache"}},
+				"db":    {},
+				"cache": {},
+			},
+			order: []string{"cache", "db", "api", "web"},
+		},
+		{
+			name:     "undefined dependency",
+			services: map[string]Service{"web": {DependsOn: []string{"api"}}},
+			err:      true,
+		},
+		{
+			name: "cycle",
+			services: map[string]Service{
+				"a": {DependsOn: []string{"b"}},
+				"b": {DependsOn: []string{"a"}},
+			},
+			err: true,
+		},
+	}
+
+	for _, c := range cases {
+		order, err := servicesOrder(c.services)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: no error, order %v", c.name, order)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(order, c.order) {
+			t.Errorf("%s: order = %v, want %v", c.name, order, c.order)
+		}
+	}
+}
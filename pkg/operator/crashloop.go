@@ -0,0 +1,194 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	dockerContainer "github.com/docker/docker/api/types/container"
+	"github.com/slntopp/nocloud-operator/pkg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCrashLoopRestarts = 5
+	defaultCrashLoopWindow   = 600 * time.Second
+
+	crashLoopNotify   = "notify"
+	crashLoopStop     = "stop"
+	crashLoopRollback = "rollback"
+)
+
+// CrashLoop is crash history of a container, kept by name as recreations change container id
+type CrashLoop struct {
+	Name         string      `json:"name"`
+	ContainerId  string      `json:"container_id"`
+	Dies         []time.Time `json:"dies"`
+	RestartCount int         `json:"restart_count"`
+	Detected     bool        `json:"detected"`
+	DetectedAt   time.Time   `json:"detected_at,omitempty"`
+	Action       string      `json:"action,omitempty"`
+}
+
+func (o *Operator) CrashLoops() []CrashLoop {
+	o.crashMutex.Lock()
+	defer o.crashMutex.Unlock()
+
+	result := make([]CrashLoop, 0, len(o.crashLoops))
+	for _, item := range o.crashLoops {
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// expectStop marks container as stopped by operator itself, so its die event isn't counted as crash
+func (o *Operator) expectStop(id string) {
+	o.expectedStops.Store(id, struct{}{})
+}
+
+// recordDie counts container death and takes crash loop action once
+// container dies configured number of times within the window
+func (o *Operator) recordDie(ctx context.Context, id string, attributes map[string]string) {
+	log := o.log.Named("crash_loop")
+
+	if _, ok := o.expectedStops.LoadAndDelete(id); ok {
+		return
+	}
+	if attributes["exitCode"] == "0" {
+		return
+	}
+
+	restarts, window := o.crashLoopLimits()
+	name := attributes["name"]
+
+	o.crashMutex.Lock()
+	state, ok := o.crashLoops[name]
+	if !ok {
+		state = &CrashLoop{Name: name}
+		o.crashLoops[name] = state
+	}
+	state.ContainerId = id
+	state.Dies = append(pruneDies(state.Dies, window), time.Now())
+	if container, _, err := o.client.ContainerInspectWithRaw(ctx, id, false); err == nil {
+		state.RestartCount = container.RestartCount
+	}
+
+	if state.Detected || len(state.Dies) < restarts {
+		o.crashMutex.Unlock()
+		return
+	}
+
+	state.Detected = true
+	state.DetectedAt = time.Now().UTC()
+	state.Action = attributes[dns.CrashLoopActionLabel]
+	if state.Action == "" {
+		state.Action = o.config.CrashLoop.Action
+	}
+	if state.Action == "" {
+		state.Action = crashLoopNotify
+	}
+	action, dies := state.Action, len(state.Dies)
+	o.crashMutex.Unlock()
+
+	log.Error("Crash loop detected", zap.String("name", name), zap.String("id", id), zap.Int("dies", dies), zap.String("action", action))
+
+	reason := fmt.Sprintf("crash loop: died %d times in %s", dies, window)
+	_, logs := o.failureReason(ctx, id, fmt.Errorf("exit code %s", attributes["exitCode"]))
+
+	switch action {
+	case crashLoopStop:
+		o.expectStop(id)
+		if err := o.client.ContainerStop(ctx, id, dockerContainer.StopOptions{}); err != nil {
+			log.Error("Fail to stop container", zap.String("name", name), zap.Error(err))
+			reason += ", stop failed: " + err.Error()
+		} else {
+			reason += ", container stopped"
+			o.scheduleDnsRemoval(name, attributes)
+		}
+	case crashLoopRollback:
+		if err := o.rollbackImage(ctx, id); err != nil {
+			log.Error("Fail to rollback container", zap.String("name", name), zap.Error(err))
+			reason += ", rollback failed: " + err.Error()
+		} else {
+			reason += ", pinned to previous image"
+		}
+	}
+
+	o.alert(ctx, Alert{ContainerId: id, Name: name, Reason: reason, Logs: logs})
+}
+
+// checkCrashLoops forgets containers which haven't died within the window
+func (o *Operator) checkCrashLoops() {
+	_, window := o.crashLoopLimits()
+
+	o.crashMutex.Lock()
+	defer o.crashMutex.Unlock()
+
+	for name, state := range o.crashLoops {
+		state.Dies = pruneDies(state.Dies, window)
+		if len(state.Dies) == 0 {
+			delete(o.crashLoops, name)
+		}
+	}
+}
+
+func (o *Operator) crashLoopLimits() (int, time.Duration) {
+	restarts := o.config.CrashLoop.Restarts
+	if restarts <= 0 {
+		restarts = defaultCrashLoopRestarts
+	}
+	return restarts, o.config.CrashLoop.Window.Or(defaultCrashLoopWindow)
+}
+
+func pruneDies(dies []time.Time, window time.Duration) []time.Time {
+	since := time.Now().Add(-window)
+	result := dies[:0]
+	for _, die := range dies {
+		if die.After(since) {
+			result = append(result, die)
+		}
+	}
+	return result
+}
+
+// rollbackImage recreates container from image it ran before last update and pins it there,
+// so updates don't bring broken image back
+func (o *Operator) rollbackImage(ctx context.Context, id string) error {
+	container, _, err := o.client.ContainerInspectWithRaw(ctx, id, false)
+	if err != nil {
+		return err
+	}
+
+	labels := container.Config.Labels
+	previous := labels[dns.PreviousImageLabel]
+	if previous == "" {
+		return fmt.Errorf("no previous image known")
+	}
+	if _, _, err := o.client.ImageInspectWithRaw(ctx, previous); err != nil {
+		return fmt.Errorf("previous image %s: %w", previous, err)
+	}
+
+	containerConfig, portBindings, networksNames := o.getContainerComposeConfig(labels, container.Config.Image)
+	if containerConfig == nil {
+		return fmt.Errorf("no compose service for image %s", container.Config.Image)
+	}
+	containerConfig.Image = previous
+
+	hostCfg := container.HostConfig
+	if len(portBindings) != 0 {
+		hostCfg.PortBindings = portBindings
+	}
+
+	labels[dns.PinnedImageLabel] = previous
+	delete(labels, dns.PreviousImageLabel)
+
+	endpointsConfig := NewEndpointsConfig(hostCfg.NetworkMode, container.NetworkSettings.Networks, container.ID)
+
+	name := strings.TrimPrefix(container.Name, "/")
+	return o.replaceContainer(ctx, id, name, func(ctx context.Context) error {
+		return o.createContainer(ctx, containerConfig, networksNames, hostCfg, name, &labels, endpointsConfig)
+	})
+}
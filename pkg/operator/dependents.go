@@ -0,0 +1,156 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	dockerContainer "github.com/docker/docker/api/types/container"
+	dockerFilters "github.com/docker/docker/api/types/filters"
+	"github.com/slntopp/nocloud-operator/pkg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	dependencyTimeout = 5 * time.Minute
+
+	dependentRestart  = "restart"
+	dependentRecreate = "recreate"
+)
+
+// dependencyRecreated queues restart of containers depending on recreated one, they are restarted
+// once it's healthy. Dependents recreated by operator don't restart their own dependents, so services
+// depending on each other don't recreate each other in loop
+func (o *Operator) dependencyRecreated(ctx context.Context, name string) {
+	if _, ok := o.dependentRecreations.LoadAndDelete(name); ok {
+		return
+	}
+	if !o.hasDependents(ctx, name) {
+		return
+	}
+
+	o.dependenciesMutex.Lock()
+	defer o.dependenciesMutex.Unlock()
+	o.dependencies[name] = time.Now()
+}
+
+// hasDependents tells if any container lists container or its compose service in nocloud.restart.on label
+func (o *Operator) hasDependents(ctx context.Context, name string) bool {
+	log := o.log.Named("has_dependents")
+
+	service := name
+	if container, err := o.client.ContainerInspect(ctx, name); err == nil {
+		if value, ok := container.Config.Labels[composeServiceLabel]; ok {
+			service = value
+		}
+	}
+
+	filters := dockerFilters.NewArgs()
+	filters.Add("label", dns.RestartOnLabel)
+	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: filters})
+	if err != nil {
+		log.Error("Error to get containers", zap.Error(err))
+		return false
+	}
+	for _, container := range containersList {
+		if dependsOn(container.Labels[dns.RestartOnLabel], service, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDependencies restarts dependents of recreated containers which came back healthy
+func (o *Operator) checkDependencies(ctx context.Context) {
+	log := o.log.Named("check_dependencies")
+
+	o.dependenciesMutex.Lock()
+	pending := make(map[string]time.Time, len(o.dependencies))
+	for name, since := range o.dependencies {
+		pending[name] = since
+	}
+	o.dependenciesMutex.Unlock()
+
+	for name, since := range pending {
+		container, err := o.client.ContainerInspect(ctx, name)
+		ready := err == nil && container.State.Running &&
+			(container.State.Health == nil || container.State.Health.Status == types.Healthy)
+		if !ready {
+			if time.Since(since) > dependencyTimeout {
+				o.forgetDependency(name)
+				o.alert(ctx, Alert{
+					ContainerId: container.ID,
+					Name:        name,
+					Reason:      fmt.Sprintf("dependents not restarted: container isn't healthy after %s", dependencyTimeout),
+				})
+			}
+			continue
+		}
+		o.forgetDependency(name)
+
+		service := name
+		if value, ok := container.Config.Labels[composeServiceLabel]; ok {
+			service = value
+		}
+		log.Info("Dependency is up, restarting dependents", zap.String("name", name), zap.String("service", service))
+		o.restartDependents(ctx, service, name)
+	}
+}
+
+func (o *Operator) forgetDependency(name string) {
+	o.dependenciesMutex.Lock()
+	defer o.dependenciesMutex.Unlock()
+	delete(o.dependencies, name)
+}
+
+// restartDependents restarts or recreates containers which nocloud.restart.on label lists service or container name
+func (o *Operator) restartDependents(ctx context.Context, service, name string) {
+	log := o.log.Named("restart_dependents")
+
+	filters := dockerFilters.NewArgs()
+	filters.Add("label", dns.RestartOnLabel)
+	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{Filters: filters})
+	if err != nil {
+		log.Error("Error to get containers", zap.Error(err))
+		return
+	}
+
+	for _, container := range containersList {
+		if len(container.Names) == 0 || !dependsOn(container.Labels[dns.RestartOnLabel], service, name) {
+			continue
+		}
+
+		dependent := strings.TrimPrefix(container.Names[0], "/")
+		action := container.Labels[dns.RestartActionLabel]
+		log.Info("Restarting dependent", zap.String("name", dependent), zap.String("dependency", service), zap.String("action", action))
+
+		switch action {
+		case dependentRecreate:
+			o.dependentRecreations.Store(dependent, struct{}{})
+			err = o.recreateContainer(ctx, container.ID)
+			o.dependentRecreations.Delete(dependent)
+		case dependentRestart, "":
+			o.expectStop(container.ID)
+			err = o.client.ContainerRestart(ctx, container.ID, dockerContainer.StopOptions{})
+		default:
+			err = fmt.Errorf("unknown restart action %s", action)
+		}
+		if err != nil {
+			log.Error("Fail to restart dependent", zap.String("name", dependent), zap.Error(err))
+		}
+	}
+}
+
+func dependsOn(value string, names ...string) bool {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		for _, name := range names {
+			if item == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package operator
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"go.uber.org/zap"
+)
+
+// dnsRemoval is pending removal of records of stopped container, kept by container name
+type dnsRemoval struct {
+	at     time.Time
+	labels map[string]string
+}
+
+// scheduleDnsRemoval removes records of stopped container after grace period,
+// unless container with the same name starts again in between
+func (o *Operator) scheduleDnsRemoval(name string, labels map[string]string) {
+	if len(containerZones(labels)) == 0 {
+		return
+	}
+
+	o.dnsRemovalsMutex.Lock()
+	defer o.dnsRemovalsMutex.Unlock()
+	if _, ok := o.dnsRemovals[name]; ok {
+		return
+	}
+	o.dnsRemovals[name] = &dnsRemoval{
+		at:     time.Now().Add(time.Duration(o.config.DnsRecords.GracePeriod)),
+		labels: labels,
+	}
+}
+
+func (o *Operator) cancelDnsRemoval(name string) {
+	o.dnsRemovalsMutex.Lock()
+	defer o.dnsRemovalsMutex.Unlock()
+	delete(o.dnsRemovals, name)
+}
+
+// checkDnsRemovals removes records of containers which grace period is over
+func (o *Operator) checkDnsRemovals(ctx context.Context) {
+	o.dnsRemovalsMutex.Lock()
+	due := false
+	for name, removal := range o.dnsRemovals {
+		if time.Now().After(removal.at) {
+			due = true
+			delete(o.dnsRemovals, name)
+		}
+	}
+	o.dnsRemovalsMutex.Unlock()
+
+	if due {
+		o.reconcileDns(ctx)
+	}
+}
+
+// pendingDnsRemovals lists names of stopped containers kept in every zone until grace period is over
+func (o *Operator) pendingDnsRemovals() map[string]map[string]struct{} {
+	o.dnsRemovalsMutex.Lock()
+	defer o.dnsRemovalsMutex.Unlock()
+
+	keep := map[string]map[string]struct{}{}
+	for _, removal := range o.dnsRemovals {
+		keepNames(keep, removal.labels)
+	}
+	return keep
+}
+
+// SweepDnsRecords removes records written by operator for containers which aren't running anymore
+func (o *Operator) SweepDnsRecords(ctx context.Context) {
+	log := o.log.Named("sweep_dns_records")
+	if o.dnsWrap == nil {
+		return
+	}
+
+	// Zones may be given by indexed labels only, so containers can't be filtered by label
+	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		log.Error("Error to get containers", zap.Error(err))
+		return
+	}
+
+	desired := map[string]map[string]struct{}{}
+	add := func(zone, name string) {
+		if _, ok := desired[zone]; !ok {
+			desired[zone] = map[string]struct{}{}
+		}
+		desired[zone][name] = struct{}{}
+	}
+	for _, container := range containersList {
+		for _, zone := range containerZones(container.Labels) {
+			for _, name := range containerNames(container.Labels) {
+				add(zone, name)
+			}
+		}
+
+		// PTR and SRV names depend on container addresses and exposed ports, not labels only
+		if len(containerZones(container.Labels)) == 0 {
+			continue
+		}
+		inspect, _, err := o.client.ContainerInspectWithRaw(ctx, container.ID, false)
+		if err != nil {
+			log.Error("Error to inspect container, sweep skipped", zap.String("id", container.ID), zap.Error(err))
+			return
+		}
+		// Names which can't be built are unknown, sweeping then could delete records of running container
+		records, complete := o.containerRecords(log, inspect)
+		if !complete {
+			log.Warn("Records of container can't be built, sweep skipped", zap.String("id", container.ID))
+			return
+		}
+		for zone, locations := range records {
+			for name := range locations {
+				add(zone, name)
+			}
+		}
+	}
+
+	if err := o.dnsWrap.Sweep(ctx, o.dnsOwner(), desired); err != nil {
+		log.Error("Fail to sweep DNS records", zap.Error(err))
+	}
+}
@@ -0,0 +1,557 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	mdns "github.com/miekg/dns"
+	"github.com/slntopp/nocloud-operator/pkg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	dnsProviderMgmt     = "dns-mgmt"
+	dnsProviderRfc2136  = "rfc2136"
+	dnsProviderFile     = "file"
+	dnsProviderEmbedded = "embedded"
+)
+
+// labelValues collects values of label given as comma separated list and as indexed labels
+// (key.0, key.1, ...), in this order. Values of TXT records may contain commas, so they aren't split
+func labelValues(labels map[string]string, key string, split bool) []string {
+	var values []string
+	if value, ok := labels[key]; ok {
+		if split {
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					values = append(values, item)
+				}
+			}
+		} else {
+			values = append(values, value)
+		}
+	}
+
+	indexes := map[int]string{}
+	for label, value := range labels {
+		suffix, ok := strings.CutPrefix(label, key+".")
+		if !ok {
+			continue
+		}
+		if index, err := strconv.Atoi(suffix); err == nil {
+			indexes[index] = value
+		}
+	}
+	keys := make([]int, 0, len(indexes))
+	for index := range indexes {
+		keys = append(keys, index)
+	}
+	sort.Ints(keys)
+	for _, index := range keys {
+		values = append(values, indexes[index])
+	}
+	return values
+}
+
+// containerZones lists zones container publishes its records into
+func containerZones(labels map[string]string) []string {
+	return labelValues(labels, dns.ZoneLabel, true)
+}
+
+// containerLocations builds records container publishes into zone from its labels: A and AAAA with
+// container addresses in labeled network, CNAME aliases and TXT values
+func (o *Operator) containerLocations(container types.ContainerJSON, zone string) (map[string]*dns.Location, error) {
+	labels := container.Config.Labels
+
+	network, ok := container.NetworkSettings.Networks[o.config.ComposePrefix+labels[dns.NetworkLabel]]
+	if !ok {
+		return nil, errors.New("no such network")
+	}
+
+	locations := map[string]*dns.Location{}
+	location := func(name string) *dns.Location {
+		if _, ok := locations[name]; !ok {
+			locations[name] = &dns.Location{}
+		}
+		return locations[name]
+	}
+
+	aNames := labelValues(labels, dns.ALabel, true)
+	for _, name := range aNames {
+		if network.IPAddress == "" {
+			return nil, errors.New("no IPv4 address in network")
+		}
+		location(name).A = append(location(name).A, network.IPAddress)
+	}
+
+	for _, name := range labelValues(labels, dns.AAAALabel, true) {
+		if network.GlobalIPv6Address == "" {
+			return nil, errors.New("no IPv6 address in network")
+		}
+		location(name).AAAA = append(location(name).AAAA, network.GlobalIPv6Address)
+	}
+
+	// TXT values are published under every A name
+	for _, txt := range labelValues(labels, dns.TxtLabel, false) {
+		if len(aNames) == 0 {
+			return nil, errors.New("TXT record requires A record name")
+		}
+		for _, name := range aNames {
+			location(name).TXT = append(location(name).TXT, txt)
+		}
+	}
+
+	// CNAME alias points to first container A name, unless target is given as alias=target
+	for _, value := range labelValues(labels, dns.CNameLabel, true) {
+		alias, target, explicit := strings.Cut(value, "=")
+		if !explicit {
+			if len(aNames) == 0 {
+				return nil, errors.New("CNAME record requires target or A record name")
+			}
+			target = fmt.Sprintf("%s.%s.", aNames[0], zone)
+		}
+		location(alias).CNAME = target
+	}
+
+	// SRV services point to first container address name
+	if o.dnsWrap.Supports(mdns.TypeSRV) {
+		services, err := containerServices(container)
+		if err != nil {
+			return nil, err
+		}
+		targets := append(append([]string{}, aNames...), labelValues(labels, dns.AAAALabel, true)...)
+		if len(services) != 0 && len(targets) == 0 {
+			return nil, errors.New("SRV record requires A record name")
+		}
+		for _, name := range sortedServices(services) {
+			for _, port := range services[name] {
+				location(name).SRV = append(location(name).SRV, dns.SRV{Target: fmt.Sprintf("%s.%s.", targets[0], zone), Port: port})
+			}
+		}
+	}
+
+	return locations, nil
+}
+
+// containerServices collects SRV names and ports of container, given by nocloud.dns.srv.<service>.<proto>
+// labels or, with nocloud.dns.srv label, taken from exposed ports
+func containerServices(container types.ContainerJSON) (map[string][]uint16, error) {
+	labels := container.Config.Labels
+	services := map[string][]uint16{}
+
+	for label, value := range labels {
+		key, ok := strings.CutPrefix(label, dns.SrvLabel+".")
+		if !ok {
+			continue
+		}
+		service, proto, ok := strings.Cut(key, ".")
+		if !ok || service == "" || proto == "" {
+			return nil, fmt.Errorf("wrong SRV label %s", label)
+		}
+		for _, item := range strings.Split(value, ",") {
+			port, err := strconv.ParseUint(strings.TrimSpace(item), 10, 16)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("wrong port %s in label %s", item, label)
+			}
+			name := srvName(service, proto)
+			services[name] = append(services[name], uint16(port))
+		}
+	}
+
+	if _, ok := labels[dns.SrvLabel]; ok {
+		service := srvService(labels)
+		if service == "" {
+			return nil, errors.New("SRV service name required")
+		}
+		for port := range container.Config.ExposedPorts {
+			name := srvName(service, port.Proto())
+			services[name] = append(services[name], uint16(port.Int()))
+		}
+	}
+
+	for name := range services {
+		sort.Slice(services[name], func(i, j int) bool { return services[name][i] < services[name][j] })
+	}
+	return services, nil
+}
+
+// srvService is service name of SRV records taken from exposed ports: nocloud.dns.srv label value or compose service
+func srvService(labels map[string]string) string {
+	if value := labels[dns.SrvLabel]; value != "" {
+		return value
+	}
+	return labels[composeServiceLabel]
+}
+
+func srvName(service, proto string) string {
+	return fmt.Sprintf("_%s._%s", service, proto)
+}
+
+func sortedServices(services map[string][]uint16) []string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// srvOnly tells if location holds SRV records only, such locations are shared by all containers providing service
+func srvOnly(location *dns.Location) bool {
+	return len(location.SRV) != 0 && len(location.A) == 0 && len(location.AAAA) == 0 &&
+		location.CNAME == "" && len(location.TXT) == 0 && len(location.PTR) == 0
+}
+
+// ptrEnabled tells if container publishes PTR records, nocloud.dns.ptr label overrides config
+func (o *Operator) ptrEnabled(labels map[string]string) bool {
+	if !o.dnsWrap.Supports(mdns.TypePTR) {
+		return false
+	}
+	if value, ok := labels[dns.PtrLabel]; ok {
+		enabled, err := strconv.ParseBool(value)
+		return err != nil || enabled
+	}
+	return o.config.DnsRecords.Ptr
+}
+
+// reverseLocations builds PTR records pointing addresses of A and AAAA names back to them,
+// keyed by reverse zone
+func (o *Operator) reverseLocations(zone string, locations map[string]*dns.Location) (map[string]map[string]*dns.Location, error) {
+	names := make([]string, 0, len(locations))
+	for name := range locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reverse := map[string]map[string]*dns.Location{}
+	for _, name := range names {
+		location := locations[name]
+		for _, ip := range append(append([]string{}, location.A...), location.AAAA...) {
+			reverseZone, reverseName, err := dns.ReverseZone(ip, o.config.DnsRecords.ReverseZones)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := reverse[reverseZone]; !ok {
+				reverse[reverseZone] = map[string]*dns.Location{}
+			}
+			if _, ok := reverse[reverseZone][reverseName]; !ok {
+				reverse[reverseZone][reverseName] = &dns.Location{}
+			}
+			ptr := reverse[reverseZone][reverseName]
+			ptr.PTR = append(ptr.PTR, fmt.Sprintf("%s.%s.", name, zone))
+		}
+	}
+	return reverse, nil
+}
+
+// containerTtl is TTL of container records in zone. Configured default is overridden by zone default,
+// then by nocloud.dns.ttl label and nocloud.dns.ttl.<type> labels
+func (o *Operator) containerTtl(labels map[string]string, zone string) (dns.TTL, error) {
+	ttl := dns.TTL{Types: map[uint16]int32{}}
+	if o.config.DnsRecords.Ttl > 0 {
+		ttl.Default = ttlSeconds(time.Duration(o.config.DnsRecords.Ttl))
+	}
+	if config, ok := o.config.DnsRecords.Zones[zone]; ok && config.Ttl > 0 {
+		ttl.Default = ttlSeconds(time.Duration(config.Ttl))
+	}
+
+	for label, value := range labels {
+		if label != dns.TtlLabel && !strings.HasPrefix(label, dns.TtlLabel+".") {
+			continue
+		}
+		seconds, err := parseTtl(value)
+		if err != nil {
+			return ttl, fmt.Errorf("wrong TTL in label %s: %w", label, err)
+		}
+		if label == dns.TtlLabel {
+			ttl.Default = seconds
+			continue
+		}
+		rrtype, ok := mdns.StringToType[strings.ToUpper(strings.TrimPrefix(label, dns.TtlLabel+"."))]
+		if !ok {
+			return ttl, fmt.Errorf("unknown record type in label %s", label)
+		}
+		ttl.Types[rrtype] = seconds
+	}
+	return ttl, nil
+}
+
+// parseTtl reads TTL given in seconds or Go duration syntax
+func parseTtl(value string) (int32, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 32); err == nil && seconds > 0 {
+		return int32(seconds), nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if duration < time.Second {
+		return 0, errors.New("TTL must be at least 1s")
+	}
+	return ttlSeconds(duration), nil
+}
+
+func ttlSeconds(duration time.Duration) int32 {
+	return int32(duration / time.Second)
+}
+
+// containerRecords builds records container publishes in every zone, including reverse zones.
+// Zones which records can't be built are logged and skipped, then records are reported as incomplete
+func (o *Operator) containerRecords(log *zap.Logger, container types.ContainerJSON) (map[string]map[string]*dns.Location, bool) {
+	name := strings.TrimPrefix(container.Name, "/")
+	ptr := o.ptrEnabled(container.Config.Labels)
+
+	records := map[string]map[string]*dns.Location{}
+	complete := true
+	add := func(zone string, locations map[string]*dns.Location) error {
+		ttl, err := o.containerTtl(container.Config.Labels, zone)
+		if err != nil {
+			return err
+		}
+		if _, ok := records[zone]; !ok {
+			records[zone] = map[string]*dns.Location{}
+		}
+		for locationName, location := range locations {
+			location.TTL = ttl
+			if current, ok := records[zone][locationName]; ok {
+				current.PTR = append(current.PTR, location.PTR...)
+				continue
+			}
+			records[zone][locationName] = location
+		}
+		return nil
+	}
+
+	for _, zone := range containerZones(container.Config.Labels) {
+		locations, err := o.containerLocations(container, zone)
+		if err != nil {
+			log.Error("Fail to get container records", zap.String("container", name), zap.String("zone", zone), zap.Error(err))
+			complete = false
+			continue
+		}
+		if err := add(zone, locations); err != nil {
+			log.Error("Fail to get container records", zap.String("container", name), zap.String("zone", zone), zap.Error(err))
+			complete = false
+			continue
+		}
+
+		if !ptr {
+			continue
+		}
+		reverse, err := o.reverseLocations(zone, locations)
+		if err != nil {
+			log.Error("Fail to get container PTR records", zap.String("container", name), zap.String("zone", zone), zap.Error(err))
+			complete = false
+			continue
+		}
+		for reverseZone, locations := range reverse {
+			if err := add(reverseZone, locations); err != nil {
+				log.Error("Fail to get container PTR records", zap.String("container", name), zap.String("zone", reverseZone), zap.Error(err))
+				complete = false
+			}
+		}
+	}
+	return records, complete
+}
+
+// keepNames adds names container publishes by its labels to kept names of its zones
+func keepNames(keep map[string]map[string]struct{}, labels map[string]string) {
+	for _, zone := range containerZones(labels) {
+		if _, ok := keep[zone]; !ok {
+			keep[zone] = map[string]struct{}{}
+		}
+		for _, name := range containerNames(labels) {
+			keep[zone][name] = struct{}{}
+		}
+	}
+}
+
+// reverseZone tells if zone is reverse zone PTR records are kept in
+func reverseZone(zone string) bool {
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	return strings.HasSuffix(zone, ".in-addr.arpa") || strings.HasSuffix(zone, ".ip6.arpa")
+}
+
+// containerNames lists names container publishes in every zone, taken from labels only,
+// so they're known for destroyed containers too
+func containerNames(labels map[string]string) []string {
+	names := labelValues(labels, dns.ALabel, true)
+	names = append(names, labelValues(labels, dns.AAAALabel, true)...)
+	for _, value := range labelValues(labels, dns.CNameLabel, true) {
+		alias, _, _ := strings.Cut(value, "=")
+		names = append(names, alias)
+	}
+	for label := range labels {
+		if key, ok := strings.CutPrefix(label, dns.SrvLabel+"."); ok {
+			service, proto, _ := strings.Cut(key, ".")
+			names = append(names, srvName(service, proto))
+		}
+	}
+	// Exposed ports aren't known from labels, so both protocols are taken
+	if service := srvService(labels); service != "" {
+		if _, ok := labels[dns.SrvLabel]; ok {
+			names = append(names, srvName(service, "tcp"), srvName(service, "udp"))
+		}
+	}
+	return names
+}
+
+// DnsConflict is name container wants to publish, which is taken by record operator doesn't own
+type DnsConflict struct {
+	Zone      string `json:"zone"`
+	Name      string `json:"name"`
+	Container string `json:"container"`
+}
+
+func (o *Operator) DnsConflicts() []DnsConflict {
+	o.dnsConflictsMutex.Lock()
+	defer o.dnsConflictsMutex.Unlock()
+
+	result := make([]DnsConflict, 0, len(o.dnsConflicts))
+	for _, conflict := range o.dnsConflicts {
+		result = append(result, conflict)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Zone+"/"+result[i].Name < result[j].Zone+"/"+result[j].Name
+	})
+	return result
+}
+
+// setDnsConflicts replaces conflicts in zone, reporting new ones
+func (o *Operator) setDnsConflicts(zone string, conflicts []DnsConflict) {
+	log := o.log.Named("dns_conflicts")
+
+	o.dnsConflictsMutex.Lock()
+	defer o.dnsConflictsMutex.Unlock()
+
+	known := map[string]struct{}{}
+	for key, conflict := range o.dnsConflicts {
+		if conflict.Zone == zone {
+			known[key] = struct{}{}
+			delete(o.dnsConflicts, key)
+		}
+	}
+	for _, conflict := range conflicts {
+		key := zone + "/" + conflict.Name
+		if _, ok := known[key]; !ok {
+			log.Error("Name is already taken", zap.String("zone", zone), zap.String("name", conflict.Name), zap.String("container", conflict.Container))
+		}
+		o.dnsConflicts[key] = conflict
+	}
+}
+
+// reconcileDns computes records every running container should publish and brings every zone operator
+// ever wrote to this state, so zones are written only when something changed
+func (o *Operator) reconcileDns(ctx context.Context) {
+	log := o.log.Named("reconcile_dns")
+	if o.dnsWrap == nil {
+		return
+	}
+
+	o.dnsMutex.Lock()
+	defer o.dnsMutex.Unlock()
+
+	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		log.Error("Error to get containers", zap.Error(err))
+		return
+	}
+	// Name taken by several containers goes to the first one by name
+	sort.Slice(containersList, func(i, j int) bool { return containersList[i].Names[0] < containersList[j].Names[0] })
+
+	// Records of containers which can't be built now are kept as they are, so lookup error never deletes them.
+	// PTR names depend on addresses, so reverse zones aren't synced at all then
+	keep := o.pendingDnsRemovals()
+	skipReverse := false
+
+	desired := map[string]map[string]dns.Desired{}
+	publishers := map[string]map[string]string{}
+	conflicts := map[string][]DnsConflict{}
+	for _, item := range containersList {
+		if len(containerZones(item.Labels)) == 0 {
+			continue
+		}
+
+		container, _, err := o.client.ContainerInspectWithRaw(ctx, item.ID, false)
+		if err != nil {
+			log.Error("Error to inspect container", zap.String("id", item.ID), zap.Error(err))
+			keepNames(keep, item.Labels)
+			skipReverse = true
+			continue
+		}
+		name := strings.TrimPrefix(container.Name, "/")
+		owner := dns.Owner{Instance: o.dnsOwner(), Container: container.ID}
+
+		for _, zone := range containerZones(item.Labels) {
+			o.dnsZones[zone] = struct{}{}
+		}
+		records, complete := o.containerRecords(log, container)
+		if !complete {
+			keepNames(keep, container.Config.Labels)
+			skipReverse = skipReverse || o.ptrEnabled(container.Config.Labels)
+		}
+		for zone, locations := range records {
+			o.dnsZones[zone] = struct{}{}
+			if _, ok := desired[zone]; !ok {
+				desired[zone], publishers[zone] = map[string]dns.Desired{}, map[string]string{}
+			}
+			for locationName, location := range locations {
+				if current, ok := desired[zone][locationName]; ok && srvOnly(current.Location) && srvOnly(location) {
+					current.Location.SRV = append(current.Location.SRV, location.SRV...)
+					continue
+				}
+				if _, ok := desired[zone][locationName]; ok {
+					conflicts[zone] = append(conflicts[zone], DnsConflict{Zone: zone, Name: locationName, Container: name})
+					continue
+				}
+				desired[zone][locationName] = dns.Desired{Location: location, Owner: owner}
+				publishers[zone][locationName] = name
+			}
+		}
+	}
+
+	for zone := range keep {
+		o.dnsZones[zone] = struct{}{}
+	}
+
+	for zone := range o.dnsZones {
+		if skipReverse && reverseZone(zone) {
+			log.Warn("Reverse zone isn't synced while records of container can't be built", zap.String("zone", zone))
+			continue
+		}
+		_, taken, err := o.dnsWrap.Sync(ctx, zone, o.dnsOwner(), desired[zone], keep[zone])
+		if err != nil {
+			log.Error("DNS Error", zap.String("zone", zone), zap.Error(err))
+			continue
+		}
+		for _, locationName := range taken {
+			conflicts[zone] = append(conflicts[zone], DnsConflict{Zone: zone, Name: locationName, Container: publishers[zone][locationName]})
+		}
+		o.setDnsConflicts(zone, conflicts[zone])
+	}
+}
+
+// dnsOwner is id of operator instance in ownership markers of its records
+func (o *Operator) dnsOwner() string {
+	if o.config.DnsRecords.Owner != "" {
+		return o.config.DnsRecords.Owner
+	}
+	return o.dnsOwnerId
+}
+
+// defaultDnsOwner is unique id of operator on its Docker host: compose project and Docker daemon id,
+// so instances electing leader on the same host share it, while other hosts don't
+func (o *Operator) defaultDnsOwner(ctx context.Context) (string, error) {
+	project := strings.TrimSuffix(o.config.ComposePrefix, "_")
+	info, err := o.client.Info(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s@%s", project, info.ID), nil
+}
@@ -0,0 +1,162 @@
+package operator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	dockerContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/slntopp/nocloud-operator/pkg/dns"
+	"go.uber.org/zap"
+)
+
+func TestLabelValues(t *testing.T) {
+	cases := []struct {
+		name   string
+		labels map[string]string
+		split  bool
+		values []string
+	}{
+		{
+			name:   "missing",
+			labels: map[string]string{"other": "value"},
+		},
+		{
+			name:   "comma separated",
+			labels: map[string]string{"key": "a, b,,c "},
+			split:  true,
+			values: []string{"a", "b", "c"},
+		},
+		{
+			name:   "not split",
+			labels: map[string]string{"key": "v=spf1 a, mx"},
+			values: []string{"v=spf1 a, mx"},
+		},
+		{
+			name:   "indexed in order after plain",
+			labels: map[string]string{"key.10": "d", "key.2": "c", "key": "a,b", "key.x": "skipped", "keyx.1": "skipped"},
+			split:  true,
+			values: []string{"a", "b", "c", "d"},
+		},
+	}
+
+	for _, c := range cases {
+		if values := labelValues(c.labels, "key", c.split); !reflect.DeepEqual(values, c.values) {
+			t.Errorf("%s: values = %v, want %v", c.name, values, c.values)
+		}
+	}
+}
+
+func TestParseTtl(t *testing.T) {
+	cases := []struct {
+		value string
+		ttl   int32
+		err   bool
+	}{
+		{value: "60", ttl: 60},
+		{value: "1m", ttl: 60},
+		{value: "1h30m", ttl: 5400},
+		{value: "1.5s", ttl: 1},
+		{value: "0", err: true},
+		{value: "-5", err: true},
+		{value: "500ms", err: true},
+		{value: "soon", err: true},
+		{value: "", err: true},
+	}
+
+	for _, c := range cases {
+		ttl, err := parseTtl(c.value)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseTtl(%q) = %d, want error", c.value, ttl)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTtl(%q): %v", c.value, err)
+			continue
+		}
+		if ttl != c.ttl {
+			t.Errorf("parseTtl(%q) = %d, want %d", c.value, ttl, c.ttl)
+		}
+	}
+}
+
+// TestContainerRecordsError checks records of container which can't be built now, e.g. while its network
+// is reconnected, are kept rather than deleted as no longer desired
+func TestContainerRecordsError(t *testing.T) {
+	ctx := context.Background()
+	provider, err := dns.NewFileProvider(t.TempDir(), dns.FormatZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &Operator{
+		config:  OperatorConfig{ComposePrefix: "nocloud_"},
+		dnsWrap: dns.NewDnsWrap(zap.NewNop(), "", "", provider),
+		log:     zap.NewNop(),
+	}
+
+	labels := map[string]string{
+		dns.ZoneLabel:    "example.com",
+		dns.NetworkLabel: "proxy",
+		dns.ALabel:       "web",
+		dns.CNameLabel:   "www",
+	}
+	container := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: "id", Name: "/web"},
+		Config:            &dockerContainer.Config{Labels: labels},
+		NetworkSettings: &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{
+			"nocloud_proxy": {IPAddress: "10.0.0.2"},
+		}},
+	}
+	owner := dns.Owner{Instance: "nocloud@host", Container: "id"}
+
+	desired := func(records map[string]map[string]*dns.Location) map[string]dns.Desired {
+		result := map[string]dns.Desired{}
+		for name, location := range records["example.com"] {
+			result[name] = dns.Desired{Location: location, Owner: owner}
+		}
+		return result
+	}
+
+	records, complete := o.containerRecords(zap.NewNop(), container)
+	if !complete {
+		t.Fatal("records of connected container are incomplete")
+	}
+	if _, _, err := o.dnsWrap.Sync(ctx, "example.com", owner.Instance, desired(records), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	container.NetworkSettings.Networks = map[string]*network.EndpointSettings{}
+	records, complete = o.containerRecords(zap.NewNop(), container)
+	if complete {
+		t.Fatal("records of disconnected container are complete")
+	}
+
+	keep := map[string]map[string]struct{}{}
+	keepNames(keep, labels)
+	changes, _, err := o.dnsWrap.Sync(ctx, "example.com", owner.Instance, desired(records), keep["example.com"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("changes = %v, want none", changes)
+	}
+}
+
+func TestReverseZone(t *testing.T) {
+	cases := map[string]bool{
+		"0.0.10.in-addr.arpa":      true,
+		"10.IN-ADDR.ARPA.":         true,
+		"8.b.d.0.1.0.0.2.ip6.arpa": true,
+		"example.com":              false,
+		"arpa.example.com":         false,
+	}
+	for zone, reverse := range cases {
+		if reverseZone(zone) != reverse {
+			t.Errorf("reverseZone(%q) = %v, want %v", zone, !reverse, reverse)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	dockerContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"github.com/slntopp/nocloud-operator/pkg/dns"
+	"go.uber.org/zap"
+)
+
+const reconcileAuto = "auto"
+
+type Drift struct {
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s: expected %q, got %q", d.Field, d.Expected, d.Actual)
+}
+
+// DriftReport describes how running container differs from its compose definition
+type DriftReport struct {
+	ContainerId string    `json:"container_id"`
+	Name        string    `json:"name"`
+	Service     string    `json:"service"`
+	Drifts      []Drift   `json:"drifts"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+func (r DriftReport) signature() string {
+	drifts := make([]string, 0, len(r.Drifts))
+	for _, drift := range r.Drifts {
+		drifts = append(drifts, drift.String())
+	}
+	return strings.Join(drifts, ";")
+}
+
+// DriftReports returns last drift report for every drifted service
+func (o *Operator) DriftReports() []DriftReport {
+	o.driftMutex.Lock()
+	defer o.driftMutex.Unlock()
+
+	result := make([]DriftReport, 0, len(o.driftReports))
+	for _, report := range o.driftReports {
+		result = append(result, report)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Service < result[j].Service })
+	return result
+}
+
+// checkDrift compares every running container with its compose definition and
+// recreates drifted ones if auto reconcile is enabled for them. Drift is logged once it appears or changes
+func (o *Operator) checkDrift(ctx context.Context) {
+	log := o.log.Named("check_drift")
+
+	o.driftMutex.Lock()
+	previous := o.driftReports
+	o.driftMutex.Unlock()
+
+	composeConfig := readComposeConfig("./docker-compose.yml", log)
+	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		log.Error("Error to get containers", zap.Error(err))
+		return
+	}
+
+	reports := map[string]DriftReport{}
+	for _, item := range containersList {
+		container, _, err := o.client.ContainerInspectWithRaw(ctx, item.ID, false)
+		if err != nil {
+			log.Error("Error to inspect container", zap.String("id", item.ID), zap.Error(err))
+			continue
+		}
+
+		serviceName, service := findComposeService(composeConfig, container.Config.Labels, container.Name)
+		if service == nil {
+			continue
+		}
+
+		report := DriftReport{
+			ContainerId: container.ID,
+			Name:        strings.TrimPrefix(container.Name, "/"),
+			Service:     serviceName,
+			Drifts:      o.compareWithCompose(composeConfig, service, container),
+			CheckedAt:   time.Now().UTC(),
+		}
+		if len(report.Drifts) == 0 {
+			continue
+		}
+
+		reports[serviceName] = report
+		if previous[serviceName].signature() != report.signature() {
+			for _, drift := range report.Drifts {
+				log.Warn("Container drifted from compose", zap.String("service", serviceName), zap.String("container", report.Name), zap.String("field", drift.Field), zap.String("expected", drift.Expected), zap.String("actual", drift.Actual))
+			}
+		}
+
+		if !o.IsLeader() || container.Config.Labels[dns.ReconcileLabel] != reconcileAuto && o.config.Reconcile != reconcileAuto {
+			continue
+		}
+
+		// Same drift persisting after reconcile means compose can't be applied as is, don't recreate in loop
+		if o.reconciled[serviceName] == report.signature() {
+			log.Debug("Drift persists after reconcile, skipping", zap.String("service", serviceName))
+			continue
+		}
+		o.reconciled[serviceName] = report.signature()
+
+		log.Info("Reconciling container", zap.String("service", serviceName), zap.String("container", report.Name))
+		if err := o.recreateFromCompose(ctx, container.ID, composeConfig, serviceName, service); err != nil {
+			log.Error("Error reconciling container", zap.String("service", serviceName), zap.Error(err))
+		}
+	}
+
+	for serviceName := range o.reconciled {
+		if _, ok := reports[serviceName]; !ok {
+			delete(o.reconciled, serviceName)
+		}
+	}
+
+	o.driftMutex.Lock()
+	o.driftReports = reports
+	o.driftMutex.Unlock()
+}
+
+func (o *Operator) compareWithCompose(config Config, service *Service, container types.ContainerJSON) []Drift {
+	var drifts []Drift
+
+	// Image pinned after crash loop differs from compose on purpose
+	_, pinned := container.Config.Labels[dns.PinnedImageLabel]
+	if service.Image != "" && !pinned && normalizeImage(service.Image) != normalizeImage(container.Config.Image) {
+		drifts = append(drifts, Drift{Field: "image", Expected: service.Image, Actual: container.Config.Image})
+	}
+
+	env := map[string]string{}
+	for _, item := range container.Config.Env {
+		key, value, _ := strings.Cut(item, "=")
+		env[key] = value
+	}
+	for key, value := range getEnvValues(service.Environment) {
+		if actual, ok := env[key]; !ok || actual != value {
+			drifts = append(drifts, Drift{Field: "env." + key, Expected: value, Actual: actual})
+		}
+	}
+
+	for key, value := range composeLabels(service) {
+		if actual, ok := container.Config.Labels[key]; !ok || actual != value {
+			drifts = append(drifts, Drift{Field: "label." + key, Expected: value, Actual: actual})
+		}
+	}
+
+	if _, bindings, err := composePorts(service); err == nil {
+		expected, actual := portBindingsString(bindings), portBindingsString(container.HostConfig.PortBindings)
+		if expected != actual {
+			drifts = append(drifts, Drift{Field: "ports", Expected: expected, Actual: actual})
+		}
+	}
+
+	drifts = append(drifts, compareMounts(o.composeMounts(config, service, container.Config.Labels[composeWorkingDirLabel]), container.Mounts)...)
+
+	if !container.HostConfig.NetworkMode.IsHost() && !container.HostConfig.NetworkMode.IsContainer() {
+		expected := o.composeNetworks(service)
+		actual := make(map[string]struct{}, len(container.NetworkSettings.Networks))
+		for name := range container.NetworkSettings.Networks {
+			actual[name] = struct{}{}
+		}
+		if setString(expected) != setString(actual) {
+			drifts = append(drifts, Drift{Field: "networks", Expected: setString(expected), Actual: setString(actual)})
+		}
+	}
+
+	expectedPolicy, actualPolicy := composeRestartPolicy(service), container.HostConfig.RestartPolicy
+	if actualPolicy.Name == "" {
+		actualPolicy.Name = "no"
+	}
+	if expectedPolicy != actualPolicy {
+		drifts = append(drifts, Drift{Field: "restart", Expected: restartPolicyString(expectedPolicy), Actual: restartPolicyString(actualPolicy)})
+	}
+
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Field < drifts[j].Field })
+	return drifts
+}
+
+func compareMounts(expected []composeMount, actual []types.MountPoint) []Drift {
+	var drifts []Drift
+
+	mounts := make(map[string]types.MountPoint, len(actual))
+	for _, item := range actual {
+		mounts[item.Destination] = item
+	}
+
+	destinations := map[string]struct{}{}
+	for _, item := range expected {
+		destinations[item.Destination] = struct{}{}
+
+		mountPoint, ok := mounts[item.Destination]
+		switch {
+		case !ok:
+			drifts = append(drifts, Drift{Field: "volume." + item.Destination, Expected: item.Source})
+		case item.Bind && strings.HasPrefix(item.Source, "/") && mountPoint.Source != item.Source:
+			drifts = append(drifts, Drift{Field: "volume." + item.Destination, Expected: item.Source, Actual: mountPoint.Source})
+		case !item.Bind && item.Source != "" && mountPoint.Name != item.Source:
+			drifts = append(drifts, Drift{Field: "volume." + item.Destination, Expected: item.Source, Actual: mountPoint.Name})
+		}
+	}
+
+	// Anonymous volumes come from image, only binds added by hand are reported
+	for _, item := range actual {
+		if _, ok := destinations[item.Destination]; !ok && item.Type == mount.TypeBind {
+			drifts = append(drifts, Drift{Field: "volume." + item.Destination, Actual: item.Source})
+		}
+	}
+
+	return drifts
+}
+
+func normalizeImage(image string) string {
+	image = strings.TrimPrefix(image, "docker.io/")
+	image = strings.TrimPrefix(image, "library/")
+	if strings.Contains(image, "@") {
+		return image
+	}
+	if i := strings.LastIndex(image, ":"); i == -1 || strings.Contains(image[i:], "/") {
+		image += ":latest"
+	}
+	return image
+}
+
+func portBindingsString(bindings nat.PortMap) string {
+	result := make([]string, 0, len(bindings))
+	for port, items := range bindings {
+		for _, binding := range items {
+			hostIp := binding.HostIP
+			if hostIp == "0.0.0.0" {
+				hostIp = ""
+			}
+			result = append(result, fmt.Sprintf("%s:%s->%s", hostIp, binding.HostPort, port))
+		}
+	}
+	sort.Strings(result)
+	return strings.Join(result, ",")
+}
+
+func setString(set map[string]struct{}) string {
+	result := make([]string, 0, len(set))
+	for key := range set {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return strings.Join(result, ",")
+}
+
+func restartPolicyString(policy dockerContainer.RestartPolicy) string {
+	if policy.MaximumRetryCount != 0 {
+		return fmt.Sprintf("%s:%d", policy.Name, policy.MaximumRetryCount)
+	}
+	return policy.Name
+}
@@ -1,8 +1,125 @@
 package operator
 
+import (
+	"sort"
+	"strings"
+
+	dockerContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// EndpointsConfig keeps per-network endpoint settings of a container,
+// so they could be restored after the container is recreated
 type EndpointsConfig struct {
-	Links     []string
-	Aliases   []string
-	IPAddress string
-	Gateway   string
+	// Primary is the network container is created in
+	Primary  string
+	Networks map[string]*network.EndpointSettings
+}
+
+func NewEndpointsConfig(networkMode dockerContainer.NetworkMode, networks map[string]*network.EndpointSettings, id string) *EndpointsConfig {
+	config := &EndpointsConfig{Networks: map[string]*network.EndpointSettings{}}
+
+	for name, value := range networks {
+		if value == nil {
+			continue
+		}
+		config.Networks[name] = copyEndpointSettings(value, id)
+	}
+
+	if _, ok := config.Networks[networkMode.NetworkName()]; ok && networkMode.IsUserDefined() {
+		config.Primary = networkMode.NetworkName()
+	} else {
+		config.Primary = config.firstNetwork()
+	}
+
+	return config
+}
+
+// Add registers network without any endpoint settings if container isn't attached to it yet
+func (e *EndpointsConfig) Add(name string) {
+	if _, ok := e.Networks[name]; ok {
+		return
+	}
+	e.Networks[name] = &network.EndpointSettings{}
+	if e.Primary == "" {
+		e.Primary = name
+	}
+}
+
+// Retain drops all networks except given ones
+func (e *EndpointsConfig) Retain(names map[string]struct{}) {
+	for name := range e.Networks {
+		if _, ok := names[name]; !ok {
+			delete(e.Networks, name)
+		}
+	}
+	if _, ok := e.Networks[e.Primary]; !ok {
+		e.Primary = e.firstNetwork()
+	}
+}
+
+// Secondary returns names of all networks except the primary one in stable order
+func (e *EndpointsConfig) Secondary() []string {
+	names := make([]string, 0, len(e.Networks))
+	for name := range e.Networks {
+		if name != e.Primary {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NetworkingConfig returns config to create container already attached to its primary network
+func (e *EndpointsConfig) NetworkingConfig() *network.NetworkingConfig {
+	if e.Primary == "" {
+		return nil
+	}
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			e.Primary: e.Networks[e.Primary],
+		},
+	}
+}
+
+func (e *EndpointsConfig) firstNetwork() string {
+	names := make([]string, 0, len(e.Networks))
+	for name := range e.Networks {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// copyEndpointSettings keeps only configurable part of endpoint, dropping
+// operational data and alias Docker adds from container short id
+func copyEndpointSettings(value *network.EndpointSettings, id string) *network.EndpointSettings {
+	settings := &network.EndpointSettings{
+		MacAddress: value.MacAddress,
+	}
+
+	if value.IPAMConfig != nil {
+		settings.IPAMConfig = value.IPAMConfig.Copy()
+	}
+
+	settings.Links = append(settings.Links, value.Links...)
+
+	for _, alias := range value.Aliases {
+		if strings.HasPrefix(id, alias) {
+			continue
+		}
+		settings.Aliases = append(settings.Aliases, alias)
+	}
+
+	if len(value.DriverOpts) != 0 {
+		settings.DriverOpts = make(map[string]string, len(value.DriverOpts))
+		for key, opt := range value.DriverOpts {
+			settings.DriverOpts[key] = opt
+		}
+	}
+
+	return settings
 }
@@ -0,0 +1,224 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	dockerFilters "github.com/docker/docker/api/types/filters"
+	"github.com/slntopp/nocloud-operator/pkg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+
+	healthStatusPrefix = "health_status: "
+
+	healthy   = "healthy"
+	unhealthy = "unhealthy"
+
+	// ownImageWindow is how long image events are taken as caused by operator's own pull or build,
+	// events come asynchronously and may arrive after pull returned
+	ownImageWindow = time.Minute
+)
+
+// ownImage marks image operator pulls or builds itself, so its image events don't start second update
+// of containers operator already updates. Returned func starts the window mark expires after
+func (o *Operator) ownImage(imageName string) func() {
+	ref := normalizeImage(imageName)
+
+	o.ownImagesMutex.Lock()
+	defer o.ownImagesMutex.Unlock()
+	o.ownImages[ref] = time.Time{}
+
+	return func() {
+		o.ownImagesMutex.Lock()
+		defer o.ownImagesMutex.Unlock()
+		o.ownImages[ref] = time.Now().Add(ownImageWindow)
+	}
+}
+
+func (o *Operator) isOwnImage(imageName string) bool {
+	ref := normalizeImage(imageName)
+
+	o.ownImagesMutex.Lock()
+	defer o.ownImagesMutex.Unlock()
+
+	until, ok := o.ownImages[ref]
+	if !ok {
+		return false
+	}
+	if until.IsZero() || time.Now().Before(until) {
+		return true
+	}
+	delete(o.ownImages, ref)
+	return false
+}
+
+func eventsFilters() dockerFilters.Args {
+	filters := dockerFilters.NewArgs()
+	filters.Add("type", events.ContainerEventType)
+	filters.Add("type", events.NetworkEventType)
+	filters.Add("type", events.ImageEventType)
+	return filters
+}
+
+// subscribeEvents opens events stream starting from cursor, so events happened while stream was down are delivered too
+func (o *Operator) subscribeEvents(ctx context.Context, since string) (<-chan events.Message, <-chan error) {
+	return o.client.Events(ctx, types.EventsOptions{Since: since, Filters: eventsFilters()})
+}
+
+func eventsCursor(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// waitDocker blocks until Docker daemon responds again, backing off between attempts.
+// Returns false if ctx is cancelled first
+func (o *Operator) waitDocker(ctx context.Context) bool {
+	log := o.log.Named("wait_docker")
+
+	delay := reconnectMinDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+
+		_, err := o.client.Ping(ctx)
+		if err == nil {
+			return true
+		}
+		log.Warn("Docker is unavailable", zap.Duration("retry_in", delay), zap.Error(err))
+
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// handleEvent reacts to single Docker event, everything missed here is fixed by periodic resync
+func (o *Operator) handleEvent(ctx context.Context, msg events.Message) {
+	switch msg.Type {
+	case events.ContainerEventType:
+		o.handleContainerEvent(ctx, msg)
+	case events.NetworkEventType:
+		o.handleNetworkEvent(ctx, msg)
+	case events.ImageEventType:
+		o.handleImageEvent(ctx, msg)
+	}
+}
+
+func (o *Operator) handleContainerEvent(ctx context.Context, msg events.Message) {
+	log := o.log.Named("container_event")
+	id, labels := msg.Actor.ID, msg.Actor.Attributes
+
+	switch {
+	case msg.Action == "start":
+		log.Info("Container started", zap.String("id", id), zap.String("name", labels["name"]))
+		o.cancelDnsRemoval(labels["name"])
+		container, err := o.getContainer(ctx, id)
+		if err == nil {
+			o.setContainer(*NewContainerInfo(&container))
+		}
+		o.reconcileDns(ctx)
+		if _, ok := labels[dns.DriverLabel]; ok {
+			o.checkDrivers(ctx)
+		}
+
+	case msg.Action == "die":
+		log.Info("Container died", zap.String("id", id), zap.String("name", labels["name"]), zap.String("exit_code", labels["exitCode"]))
+		// Containers replaced by operator keep their records for the replacement
+		if _, ok := o.expectedStops.Load(id); !ok {
+			o.scheduleDnsRemoval(labels["name"], labels)
+		}
+		o.recordDie(ctx, id, labels)
+
+	case msg.Action == "destroy":
+		log.Info("Container destroyed", zap.String("id", id), zap.String("name", labels["name"]))
+		o.forgetContainer(id)
+		o.expectedStops.Delete(id)
+		o.forgetUnhealthy(id)
+		if _, ok := labels[dns.DriverLabel]; ok {
+			o.checkDrivers(ctx)
+		}
+
+	case strings.HasPrefix(msg.Action, healthStatusPrefix):
+		status := strings.TrimPrefix(msg.Action, healthStatusPrefix)
+		log.Info("Container health status", zap.String("id", id), zap.String("name", labels["name"]), zap.String("status", status))
+		if status == unhealthy {
+			o.watchUnhealthy(id, labels)
+		} else {
+			o.forgetUnhealthy(id)
+		}
+	}
+}
+
+func (o *Operator) handleNetworkEvent(ctx context.Context, msg events.Message) {
+	log := o.log.Named("network_event")
+	containerId := msg.Actor.Attributes["container"]
+	if containerId == "" {
+		return
+	}
+
+	switch msg.Action {
+	case "connect", "disconnect":
+		log.Info("Container network changed", zap.String("action", msg.Action), zap.String("network", msg.Actor.Attributes["name"]), zap.String("container", containerId))
+		if o.knownContainer(containerId) {
+			o.reconcileDns(ctx)
+		}
+	}
+}
+
+// handleImageEvent rolls containers labeled for update once new image appears under their tag,
+// e.g. pulled by hand
+func (o *Operator) handleImageEvent(ctx context.Context, msg events.Message) {
+	log := o.log.Named("image_event")
+
+	var imageName string
+	switch msg.Action {
+	case "pull":
+		imageName = msg.Actor.ID
+	case "tag":
+		imageName = msg.Actor.Attributes["name"]
+	default:
+		return
+	}
+	if imageName == "" {
+		return
+	}
+	if o.isOwnImage(imageName) {
+		log.Debug("Image changed by operator itself", zap.String("image", imageName))
+		return
+	}
+
+	filters := dockerFilters.NewArgs()
+	filters.Add("label", dns.UpdateLabel)
+	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{Filters: filters})
+	if err != nil {
+		log.Error("Error to get containers", zap.Error(err))
+		return
+	}
+
+	for _, item := range containersList {
+		container, _, err := o.client.ContainerInspectWithRaw(ctx, item.ID, false)
+		if err != nil {
+			log.Error("Error to inspect container", zap.String("id", item.ID), zap.Error(err))
+			continue
+		}
+		// List shows image id once tag moved to another image, so reference is taken from config
+		if normalizeImage(container.Config.Image) != normalizeImage(imageName) {
+			continue
+		}
+
+		log.Info("New image for container", zap.String("image", imageName), zap.String("container", container.Name))
+		endpointsConfig := NewEndpointsConfig(container.HostConfig.NetworkMode, container.NetworkSettings.Networks, container.ID)
+		o.updateImageAndContainer(ctx, imageName, container.Image, container.ID, container.Name, container.HostConfig, container.Config.Labels, endpointsConfig)
+	}
+}
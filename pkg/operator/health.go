@@ -0,0 +1,192 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	dockerContainer "github.com/docker/docker/api/types/container"
+	dockerFilters "github.com/docker/docker/api/types/filters"
+	"github.com/slntopp/nocloud-operator/pkg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHealthCooldown = 300 * time.Second
+	maxRemediations       = 100
+
+	healthRestart  = "restart"
+	healthRecreate = "recreate"
+	healthRollback = "rollback"
+	healthNotify   = "notify"
+)
+
+// Remediation is an action watchdog took on unhealthy container
+type Remediation struct {
+	ContainerId   string    `json:"container_id"`
+	Name          string    `json:"name"`
+	Action        string    `json:"action"`
+	FailingStreak int       `json:"failing_streak"`
+	Time          time.Time `json:"time"`
+	Error         string    `json:"error,omitempty"`
+}
+
+type unhealthyContainer struct {
+	remediated time.Time
+}
+
+func (o *Operator) Remediations() []Remediation {
+	o.healthMutex.Lock()
+	defer o.healthMutex.Unlock()
+
+	result := make([]Remediation, len(o.remediations))
+	copy(result, o.remediations)
+	return result
+}
+
+// watchUnhealthy starts tracking container reported unhealthy, only labeled containers are remediated
+func (o *Operator) watchUnhealthy(id string, labels map[string]string) {
+	if _, ok := labels[dns.HealthActionLabel]; !ok {
+		return
+	}
+
+	o.healthMutex.Lock()
+	defer o.healthMutex.Unlock()
+	if _, ok := o.unhealthy[id]; !ok {
+		o.unhealthy[id] = &unhealthyContainer{}
+	}
+}
+
+func (o *Operator) forgetUnhealthy(id string) {
+	o.healthMutex.Lock()
+	defer o.healthMutex.Unlock()
+	delete(o.unhealthy, id)
+}
+
+// findUnhealthy picks up labeled containers which were unhealthy before operator started
+func (o *Operator) findUnhealthy(ctx context.Context) {
+	log := o.log.Named("find_unhealthy")
+
+	filters := dockerFilters.NewArgs()
+	filters.Add("label", dns.HealthActionLabel)
+	filters.Add("health", types.Unhealthy)
+	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{Filters: filters})
+	if err != nil {
+		log.Error("Error to get containers", zap.Error(err))
+		return
+	}
+
+	for _, container := range containersList {
+		o.watchUnhealthy(container.ID, container.Labels)
+	}
+}
+
+// checkUnhealthy remediates tracked containers once their failing streak reaches threshold,
+// each container is remediated at most once per cooldown
+func (o *Operator) checkUnhealthy(ctx context.Context) {
+	log := o.log.Named("check_unhealthy")
+
+	o.healthMutex.Lock()
+	ids := make([]string, 0, len(o.unhealthy))
+	for id := range o.unhealthy {
+		ids = append(ids, id)
+	}
+	o.healthMutex.Unlock()
+
+	for _, id := range ids {
+		container, _, err := o.client.ContainerInspectWithRaw(ctx, id, false)
+		if err != nil || container.State == nil || container.State.Health == nil ||
+			container.State.Health.Status != types.Unhealthy {
+			o.forgetUnhealthy(id)
+			continue
+		}
+
+		labels := container.Config.Labels
+		threshold, cooldown := o.healthLimits(labels)
+		streak := container.State.Health.FailingStreak
+		if streak < threshold {
+			continue
+		}
+
+		o.healthMutex.Lock()
+		state, ok := o.unhealthy[id]
+		if !ok || time.Since(state.remediated) < cooldown {
+			o.healthMutex.Unlock()
+			continue
+		}
+		state.remediated = time.Now()
+		o.healthMutex.Unlock()
+
+		name := strings.TrimPrefix(container.Name, "/")
+		action := labels[dns.HealthActionLabel]
+		log.Warn("Remediating unhealthy container", zap.String("name", name), zap.String("action", action), zap.Int("failing_streak", streak))
+
+		err = o.remediate(ctx, id, action)
+		remediation := Remediation{
+			ContainerId:   id,
+			Name:          name,
+			Action:        action,
+			FailingStreak: streak,
+			Time:          time.Now().UTC(),
+		}
+		reason := fmt.Sprintf("unhealthy: %d failed checks, action %s", streak, action)
+		if err != nil {
+			log.Error("Fail to remediate container", zap.String("name", name), zap.Error(err))
+			remediation.Error = err.Error()
+			reason += " failed: " + err.Error()
+		}
+
+		o.healthMutex.Lock()
+		o.remediations = append(o.remediations, remediation)
+		if len(o.remediations) > maxRemediations {
+			o.remediations = o.remediations[len(o.remediations)-maxRemediations:]
+		}
+		o.healthMutex.Unlock()
+
+		// Recreated containers get new id, their health is tracked again from events
+		if action == healthRecreate || action == healthRollback {
+			o.forgetUnhealthy(id)
+		}
+
+		o.alert(ctx, Alert{ContainerId: id, Name: name, Reason: reason, Logs: healthLogs(container)})
+	}
+}
+
+func (o *Operator) remediate(ctx context.Context, id, action string) error {
+	switch action {
+	case healthRestart:
+		o.expectStop(id)
+		return o.client.ContainerRestart(ctx, id, dockerContainer.StopOptions{})
+	case healthRecreate:
+		return o.recreateContainer(ctx, id)
+	case healthRollback:
+		return o.rollbackImage(ctx, id)
+	case healthNotify, "":
+		return nil
+	default:
+		return fmt.Errorf("unknown health action %s", action)
+	}
+}
+
+func (o *Operator) healthLimits(labels map[string]string) (int, time.Duration) {
+	threshold := o.config.Health.Threshold
+	if value, ok := labels[dns.HealthThresholdLabel]; ok {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			threshold = parsed
+		}
+	}
+
+	return threshold, o.config.Health.Cooldown.Or(defaultHealthCooldown)
+}
+
+// healthLogs joins output of recent health checks
+func healthLogs(container types.ContainerJSON) string {
+	var logs []string
+	for _, result := range container.State.Health.Log {
+		logs = append(logs, fmt.Sprintf("%s exit %d: %s", result.End.Format(time.RFC3339), result.ExitCode, strings.TrimSpace(result.Output)))
+	}
+	return strings.Join(logs, "\n")
+}
@@ -0,0 +1,146 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/slntopp/nocloud-operator/pkg/leader"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLeaderKey = "nocloud-operator-leader"
+	defaultLeaderTtl = 15 * time.Second
+)
+
+type LeaderStatus struct {
+	Enabled  bool   `json:"enabled"`
+	Identity string `json:"identity,omitempty"`
+	Leading  bool   `json:"leading"`
+}
+
+// SetupLeaderElection configures lock backend from operator config, election stays off if no backend is set
+func (o *Operator) SetupLeaderElection(rdb *redis.Client) error {
+	config := o.config.Leader
+	if config.Backend == "" {
+		return nil
+	}
+
+	key := config.Key
+	if key == "" {
+		key = defaultLeaderKey
+	}
+
+	var lock leader.Lock
+	switch config.Backend {
+	case "redis":
+		lock = leader.NewRedisLock(rdb, key)
+	case "file":
+		if config.Path == "" {
+			return fmt.Errorf("leader lock path is required for file backend")
+		}
+		lock = leader.NewFileLock(config.Path)
+	case "docker":
+		lock = leader.NewDockerLock(o.client, key)
+	default:
+		return fmt.Errorf("unknown leader lock backend %s", config.Backend)
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	o.log.Info("Leader election enabled", zap.String("backend", config.Backend), zap.String("identity", identity))
+	o.elector = leader.NewElector(o.log, lock, identity, config.Ttl.Or(defaultLeaderTtl))
+	return nil
+}
+
+// RunLeaderElection keeps competing for leadership until ctx is cancelled
+func (o *Operator) RunLeaderElection(ctx context.Context) {
+	if o.elector == nil {
+		return
+	}
+	o.elector.Run(ctx)
+}
+
+// AwaitLeadership blocks until operator becomes leader, returns false if ctx is cancelled first
+func (o *Operator) AwaitLeadership(ctx context.Context) bool {
+	if o.elector == nil {
+		return true
+	}
+	o.log.Info("Waiting for leadership")
+	return o.elector.Await(ctx)
+}
+
+// IsLeader reports whether operator may change state, always true without election
+func (o *Operator) IsLeader() bool {
+	return o.elector == nil || o.elector.IsLeader()
+}
+
+// LeaderContext returns ctx cancelled once current leadership ends, so leader-only work stops on
+// instance which was demoted. It's cancelled already if operator isn't leader
+func (o *Operator) LeaderContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.elector == nil {
+		return context.WithCancel(ctx)
+	}
+	return termContext(ctx, o.elector.Term())
+}
+
+func termContext(ctx context.Context, term <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-term:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// leaderTerm keeps context of leadership term across operator loop iterations
+type leaderTerm struct {
+	term   <-chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// leading returns context of current leadership term derived from ctx, ok is false on followers
+func (o *Operator) leading(ctx context.Context, current *leaderTerm) (context.Context, bool) {
+	if o.elector == nil {
+		return ctx, true
+	}
+
+	term := o.elector.Term()
+	select {
+	case <-term:
+		return nil, false
+	default:
+	}
+
+	if current.term != term {
+		if current.cancel != nil {
+			current.cancel()
+		}
+		current.term = term
+		current.ctx, current.cancel = termContext(ctx, term)
+	}
+	return current.ctx, true
+}
+
+func (t *leaderTerm) end() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+func (o *Operator) LeaderStatus() LeaderStatus {
+	if o.elector == nil {
+		return LeaderStatus{Leading: true}
+	}
+	return LeaderStatus{Enabled: true, Identity: o.elector.Identity, Leading: o.elector.IsLeader()}
+}
@@ -17,14 +17,15 @@ import (
 	"time"
 
 	"github.com/docker/go-connections/nat"
+	mdns "github.com/miekg/dns"
 	"github.com/slntopp/nocloud-operator/pkg/dns"
+	"github.com/slntopp/nocloud-operator/pkg/leader"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 
 	"github.com/docker/docker/api/types"
 	dockerContainer "github.com/docker/docker/api/types/container"
 	dockerFilters "github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/network"
 	reg "github.com/docker/docker/api/types/registry"
 	dockerClient "github.com/docker/docker/client"
 )
@@ -45,19 +46,55 @@ type Operator struct {
 	containers map[string]ContainerInfo
 	config     OperatorConfig
 	dnsWrap    *dns.DnsWrap
+	dnsOwnerId string
 	mutex      sync.Mutex
+	// containersMutex guards containers and drivers, which update checks change from their goroutines
+	containersMutex sync.RWMutex
 	//traefikClient *traefik.TraefikClient
 	//traefikId     string
 	token        string
 	dockerTokens []string
 	defaultDns   []string
 
-	notRunningContainers []string
-	networkNames         map[string]*map[string]struct{}
-	endpoints            map[string]*EndpointsConfig
+	notRunningContainers map[string]*NotRunningContainer
+	retryMutex           sync.Mutex
+
+	driftReports map[string]DriftReport
+	driftMutex   sync.Mutex
+	reconciled   map[string]string
+
+	crashLoops    map[string]*CrashLoop
+	crashMutex    sync.Mutex
+	expectedStops sync.Map
+
+	unhealthy    map[string]*unhealthyContainer
+	remediations []Remediation
+	healthMutex  sync.Mutex
+
+	updates        map[string]*updateSchedule
+	selfUpdateNext time.Time
+	updatesMutex   sync.Mutex
+	updatesWg      sync.WaitGroup
+
+	dependencies      map[string]time.Time
+	dependenciesMutex sync.Mutex
+	// dependentRecreations are names of dependents being recreated by restartDependents
+	dependentRecreations sync.Map
+
+	ownImages      map[string]time.Time
+	ownImagesMutex sync.Mutex
+
+	dnsRemovals       map[string]*dnsRemoval
+	dnsRemovalsMutex  sync.Mutex
+	dnsConflicts      map[string]DnsConflict
+	dnsConflictsMutex sync.Mutex
+	dnsZones          map[string]struct{}
+	dnsMutex          sync.Mutex
 
 	drivers []string
 
+	elector *leader.Elector
+
 	log *zap.Logger
 }
 
@@ -113,34 +150,25 @@ func NewOperator(logger *zap.Logger, token string) *Operator {
 		defaultDns:   data.Dns,
 		drivers:      []string{},
 		dockerTokens: dockerTokens,
+
+		notRunningContainers: map[string]*NotRunningContainer{},
+		driftReports:         map[string]DriftReport{},
+		reconciled:           map[string]string{},
+		crashLoops:           map[string]*CrashLoop{},
+		unhealthy:            map[string]*unhealthyContainer{},
+		updates:              map[string]*updateSchedule{},
+		dependencies:         map[string]time.Time{},
+		ownImages:            map[string]time.Time{},
+		dnsRemovals:          map[string]*dnsRemoval{},
+		dnsConflicts:         map[string]DnsConflict{},
+		dnsZones:             map[string]struct{}{},
 	}
 
 	return operator
 }
 
-func (o *Operator) Wait() {
-	wait := true
-	log := o.log.Named("wait")
-	config := readComposeConfig("./docker-compose.yml", log)
-	for wait {
-		list, err := o.client.ContainerList(context.Background(), types.ContainerListOptions{})
-		if err != nil {
-			return
-		}
-
-		log.Info("docker-compose", zap.Int("count", len(config.Services)))
-		log.Info("list", zap.Int("count", len(list)))
-		if len(config.Services) == len(list) {
-			wait = false
-		}
-		log.Info("Waiting for all containers start")
-		time.Sleep(5 * time.Second)
-	}
-}
-
-func (o *Operator) ConfigureDns() error {
+func (o *Operator) ConfigureDns(ctx context.Context) error {
 	log := o.log.Named("configure_dns")
-	ctx := context.Background()
 	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{})
 	if err != nil {
 		return err
@@ -169,18 +197,80 @@ func (o *Operator) ConfigureDns() error {
 			dnsMgmtCheck = true
 		}
 
-		if dnsCheck && dnsMgmtCheck {
-			o.dnsWrap = dns.NewDnsWrap(log, dnsNetworkName, dnsIp, dnsMgmtHost)
-			return nil
+	}
+
+	o.dnsOwnerId, err = o.defaultDnsOwner(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch o.config.DnsRecords.Audit {
+	case "", dns.AuditInline, dns.AuditSeparate, dns.AuditOff:
+	default:
+		return fmt.Errorf("unknown dns audit mode %s", o.config.DnsRecords.Audit)
+	}
+
+	var provider dns.Provider
+	config := o.config.DnsProvider
+	switch config.Type {
+	case "", dnsProviderMgmt:
+		if !dnsCheck || !dnsMgmtCheck {
+			return errors.New("no dns server")
 		}
+		provider, err = dns.NewMgmtProvider(dnsMgmtHost)
+	case dnsProviderRfc2136:
+		provider = dns.NewRfc2136Provider(config.Server, config.Zones, config.TsigKey, config.TsigSecret, config.TsigAlgorithm)
+	case dnsProviderFile:
+		provider, err = dns.NewFileProvider(config.Directory, config.Format)
+	case dnsProviderEmbedded:
+		provider, err = dns.NewServer(o.log, config.Listen, o.defaultDns)
+		if err == nil && config.Network != "" {
+			ip, err := o.selfIpInNetwork(ctx, config.Network)
+			if err != nil {
+				log.Warn("Can't find operator address, embedded DNS server won't be set to containers", zap.Error(err))
+			} else {
+				dnsIp, dnsNetworkName = ip, config.Network
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown dns provider %s", config.Type)
+	}
+	if err != nil {
+		return err
+	}
 
+	log.Info("DNS configured", zap.String("provider", config.Type), zap.String("server", dnsIp))
+	o.dnsWrap = dns.NewDnsWrap(log, dnsNetworkName, dnsIp, provider)
+	o.dnsWrap.Audit = o.config.DnsRecords.Audit
+	if o.config.DnsRecords.Ptr && !o.dnsWrap.Supports(mdns.TypePTR) {
+		log.Warn("DNS provider can't keep PTR records, they won't be published", zap.String("provider", config.Type))
 	}
-	return errors.New("no dns server")
+	return nil
 }
 
-func (o *Operator) SetDnsIpToContainers() error {
+// selfIpInNetwork is address of operator container in network, containers reach embedded DNS server at
+func (o *Operator) selfIpInNetwork(ctx context.Context, network string) (string, error) {
+	self, err := o.selfContainer(ctx)
+	if err != nil {
+		return "", err
+	}
+	return o.getIpInNetwork(ctx, self.ID, network)
+}
+
+// Close releases connections operator holds
+func (o *Operator) Close() {
+	if o.dnsWrap != nil {
+		if err := o.dnsWrap.Close(); err != nil {
+			o.log.Warn("Error closing DNS connection", zap.Error(err))
+		}
+	}
+	if err := o.client.Close(); err != nil {
+		o.log.Warn("Error closing Docker client", zap.Error(err))
+	}
+}
+
+func (o *Operator) SetDnsIpToContainers(ctx context.Context) error {
 	log := o.log.Named("set_dns_ip")
-	ctx := context.Background()
 	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{})
 	if err != nil {
 		return err
@@ -190,6 +280,9 @@ func (o *Operator) SetDnsIpToContainers() error {
 		if _, ok := container.Labels[dns.DnsRequiredLabel]; ok {
 			err := o.recreateContainer(ctx, container.ID)
 			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
 				log.Fatal("Fail to set DNS ip", zap.String("err", err.Error()))
 			}
 		}
@@ -216,31 +309,62 @@ func (o *Operator) recreateContainer(ctx context.Context, id string) error {
 		return err
 	}
 
-	endpointsConfig := getLinksAndAliases(container.NetworkSettings.Networks, container.ID)
+	endpointsConfig := NewEndpointsConfig(container.HostConfig.NetworkMode, container.NetworkSettings.Networks, container.ID)
 
-	options := dockerContainer.StopOptions{
-		Signal:  "SIGKILL",
-		Timeout: nil,
+	err = o.checkPortConflicts(ctx, id, o.desiredPortBindings(labels, image.RepoTags[0], container.HostConfig))
+	if err != nil {
+		return err
 	}
-	err = o.client.ContainerStop(ctx, id, options)
+
+	return o.replaceContainer(ctx, id, container.Name, func(ctx context.Context) error {
+		return o.createNewContainer(ctx, image.RepoTags[0], container.HostConfig, container.Name, &labels, endpointsConfig)
+	})
+}
+
+// recreateFromCompose recreates container applying its compose definition, so manual changes
+// made with docker run or docker update are reverted
+func (o *Operator) recreateFromCompose(ctx context.Context, id string, config Config, name string, service *Service) error {
+	log := o.log.Named("recreate_from_compose")
+	container, _, err := o.client.ContainerInspectWithRaw(ctx, id, false)
 	if err != nil {
 		return err
 	}
 
-	err = o.client.ContainerRemove(ctx, id, types.ContainerRemoveOptions{})
+	labels := container.Config.Labels
+	for key, value := range composeLabels(service) {
+		labels[key] = value
+	}
+
+	hostCfg := container.HostConfig
+	hostCfg.RestartPolicy = composeRestartPolicy(service)
+	_, hostCfg.PortBindings, err = composePorts(service)
 	if err != nil {
 		return err
 	}
+	hostCfg.Binds = composeBinds(o.composeMounts(config, service, labels[composeWorkingDirLabel]))
+	hostCfg.Mounts = nil
 
-	names := o.containers[id].Names
-	log.Info("Container stopped", zap.String("id", id), zap.Strings("names", names))
-	delete(o.containers, id)
+	networks := o.composeNetworks(service)
+	endpointsConfig := NewEndpointsConfig(hostCfg.NetworkMode, container.NetworkSettings.Networks, container.ID)
+	endpointsConfig.Retain(networks)
+	if _, ok := networks[hostCfg.NetworkMode.NetworkName()]; !ok && hostCfg.NetworkMode.IsUserDefined() {
+		hostCfg.NetworkMode = ""
+	}
+
+	image := o.serviceImage(name, service)
+	if _, _, err := o.client.ImageInspectWithRaw(ctx, image); err != nil {
+		log.Info("Pulling image", zap.String("tag", image))
+		o.pullImage(ctx, image)
+	}
 
-	err = o.createNewContainer(ctx, image.RepoTags[0], container.HostConfig, container.Name, &labels, endpointsConfig)
+	err = o.checkPortConflicts(ctx, id, hostCfg.PortBindings)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return o.replaceContainer(ctx, id, container.Name, func(ctx context.Context) error {
+		return o.createNewContainer(ctx, image, hostCfg, container.Name, &labels, endpointsConfig)
+	})
 }
 
 /*
@@ -311,59 +435,142 @@ func (o *Operator) RestartTraefik(ctx context.Context, id string) {
 }
 */
 
-func (o *Operator) Ps() map[string]ContainerInfo {
+func (o *Operator) Ps(ctx context.Context) map[string]ContainerInfo {
 	log := o.log.Named("ps")
 
-	for key := range o.containers {
-		delete(o.containers, key)
-	}
-
-	ctx := context.Background()
 	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+o.token)
 	containers, err := o.client.ContainerList(ctx, types.ContainerListOptions{})
 	if err != nil {
+		if ctx.Err() != nil {
+			return o.containersCopy()
+		}
 		log.Fatal("Error listing Containers", zap.Error(err))
 	}
 
+	infos := make(map[string]ContainerInfo, len(containers))
 	for _, container := range containers {
-		o.containers[container.ID] = *NewContainerInfo(&container)
-		o.configureDnsMgmtRecords(ctx, container.ID)
+		infos[container.ID] = *NewContainerInfo(&container)
 	}
-	return o.containers
+	o.containersMutex.Lock()
+	o.containers = infos
+	o.containersMutex.Unlock()
+
+	o.reconcileDns(ctx)
+	return o.containersCopy()
+}
+
+// containersCopy is snapshot of known containers, safe to iterate while update checks change them
+func (o *Operator) containersCopy() map[string]ContainerInfo {
+	o.containersMutex.RLock()
+	defer o.containersMutex.RUnlock()
+
+	result := make(map[string]ContainerInfo, len(o.containers))
+	for id, info := range o.containers {
+		result[id] = info
+	}
+	return result
+}
+
+func (o *Operator) setContainer(info ContainerInfo) {
+	o.containersMutex.Lock()
+	defer o.containersMutex.Unlock()
+	o.containers[info.Id] = info
+}
+
+func (o *Operator) forgetContainer(id string) {
+	o.containersMutex.Lock()
+	defer o.containersMutex.Unlock()
+	delete(o.containers, id)
+}
+
+func (o *Operator) knownContainer(id string) bool {
+	o.containersMutex.RLock()
+	defer o.containersMutex.RUnlock()
+	_, ok := o.containers[id]
+	return ok
 }
 
-func (o *Operator) ObserveContainers() {
+// ObserveContainers runs operator loop until ctx is cancelled. Recreations in progress are
+// finished (or rolled back) before it returns
+func (o *Operator) ObserveContainers(ctx context.Context) {
 	log := o.log.Named("Observer")
 
-	ctx := context.Background()
 	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+o.token)
-	_, errorsChan := o.client.Events(ctx, types.EventsOptions{})
+	since := eventsCursor(time.Now())
+	messages, errorsChan := o.subscribeEvents(ctx, since)
 
-	ticker := time.NewTicker(time.Duration(o.config.Duration) * time.Second)
+	ticker := time.NewTicker(o.config.Duration.Or(defaultUpdateInterval))
 	defer ticker.Stop()
 
+	retryTicker := time.NewTicker(time.Second)
+	defer retryTicker.Stop()
+
+	// Leader-only work runs under context of leadership term, it's cancelled once leadership is lost
+	var term leaderTerm
+	defer term.end()
+
 	for {
 		select {
+		case <-ctx.Done():
+			log.Info("Stopping observer", zap.Error(ctx.Err()))
+			o.updatesWg.Wait()
+			return
+		case msg := <-messages:
+			if msg.TimeNano != 0 {
+				since = eventsCursor(time.Unix(0, msg.TimeNano))
+			}
+			if leaderCtx, ok := o.leading(ctx, &term); ok {
+				o.handleEvent(leaderCtx, msg)
+			}
 		case <-ticker.C:
-			var wg sync.WaitGroup
-			log.Info("count of containers", zap.Int("count", len(o.containers)))
-			wg.Add(len(o.containers))
-			o.Ps()
-			for _, container := range o.containers {
-				go o.checkHash(ctx, container.Id, container.Image, &wg)
+			leaderCtx, ok := o.leading(ctx, &term)
+			if !ok {
+				continue
 			}
-			wg.Wait()
-			//o.CheckTraefik(ctx)
-			o.checkDrivers(ctx)
+			o.resync(leaderCtx)
+			o.checkCrashLoops()
 			log.Info("Another cycle")
+		case <-retryTicker.C:
+			if leaderCtx, ok := o.leading(ctx, &term); ok {
+				o.retryNotRunning(leaderCtx)
+				o.checkUnhealthy(leaderCtx)
+				o.checkUpdates(leaderCtx)
+				o.checkDependencies(leaderCtx)
+				o.checkDnsRemovals(leaderCtx)
+			}
 		case err := <-errorsChan:
+			if ctx.Err() != nil {
+				continue
+			}
 			log.Error("Error in channel", zap.Error(err))
-		default:
-			continue
+			if !o.waitDocker(ctx) {
+				continue
+			}
+			log.Info("Resubscribing to events", zap.String("since", since))
+			messages, errorsChan = o.subscribeEvents(ctx, since)
+			if leaderCtx, ok := o.leading(ctx, &term); ok {
+				o.resync(leaderCtx)
+			}
 		}
 	}
 }
 
+// resync runs full check of all containers, catching up with everything events didn't cover
+func (o *Operator) resync(ctx context.Context) {
+	log := o.log.Named("resync")
+
+	o.UpComposeServices(ctx)
+	log.Info("count of containers", zap.Int("count", len(o.Ps(ctx))))
+	if ctx.Err() != nil {
+		return
+	}
+	//o.CheckTraefik(ctx)
+	o.checkDrivers(ctx)
+	o.checkDrift(ctx)
+	o.checkBuilds(ctx)
+	o.findUnhealthy(ctx)
+}
+
 func (o *Operator) checkDrivers(ctx context.Context) {
 	log := o.log.Named("check_drivers")
 	var drivers = make([]string, 0)
@@ -392,12 +599,14 @@ func (o *Operator) checkDrivers(ctx context.Context) {
 		}
 	}
 
-	sort.Strings(o.drivers)
 	sort.Strings(drivers)
 
-	if !reflect.DeepEqual(o.drivers, drivers) {
-		o.drivers = drivers
+	o.containersMutex.Lock()
+	changed := !reflect.DeepEqual(o.drivers, drivers)
+	o.drivers = drivers
+	o.containersMutex.Unlock()
 
+	if changed {
 		for _, container := range containersList {
 			if _, ok := container.Labels[dns.WithDriversLabel]; ok {
 				err := o.recreateContainer(ctx, container.ID)
@@ -413,6 +622,8 @@ func (o *Operator) checkDrivers(ctx context.Context) {
 
 func (o *Operator) checkHash(ctx context.Context, containerId, containerName string, wg *sync.WaitGroup) {
 	log := o.log.Named("check_hash")
+	defer wg.Done()
+
 	container, _, err := o.client.ContainerInspectWithRaw(ctx, containerId, false)
 	if err != nil {
 		return
@@ -420,9 +631,12 @@ func (o *Operator) checkHash(ctx context.Context, containerId, containerName str
 
 	labels := container.Config.Labels
 
-	defer wg.Done()
-
 	if _, ok := container.Config.Labels[dns.UpdateLabel]; ok {
+		if pinned, ok := labels[dns.PinnedImageLabel]; ok {
+			log.Info("Image is pinned, skipping update", zap.String("name", containerName), zap.String("image", pinned))
+			return
+		}
+
 		image, _, err := o.client.ImageInspectWithRaw(ctx, container.Image)
 		if err != nil {
 			log.Error("Image inspect with raw", zap.String("err", err.Error()))
@@ -434,7 +648,7 @@ func (o *Operator) checkHash(ctx context.Context, containerId, containerName str
 			return
 		}
 
-		endpointsConfig := getLinksAndAliases(container.NetworkSettings.Networks, container.ID)
+		endpointsConfig := NewEndpointsConfig(container.HostConfig.NetworkMode, container.NetworkSettings.Networks, container.ID)
 		log.Info("Pulling image", zap.String("tag", image.RepoTags[0]))
 		o.pullImage(ctx, image.RepoTags[0])
 
@@ -446,6 +660,7 @@ func (o *Operator) checkHash(ctx context.Context, containerId, containerName str
 
 func (o *Operator) pullImage(ctx context.Context, imageName string) {
 	log := o.log.Named("pull_image")
+	defer o.ownImage(imageName)()
 
 	for _, token := range o.dockerTokens {
 		out, err := o.client.ImagePull(ctx, imageName, types.ImagePullOptions{
@@ -474,43 +689,58 @@ func (o *Operator) pullImage(ctx context.Context, imageName string) {
 func (o *Operator) updateImageAndContainer(ctx context.Context, imageName string, imageId string, containerId string, containerName string, hostCfg *dockerContainer.HostConfig, labels map[string]string, endpointsCfg *EndpointsConfig) {
 	log := o.log.Named("update_image_and_container")
 
-	image := o.getImage(ctx, imageName)
+	image, err := o.getImage(ctx, imageName)
+	if err != nil {
+		log.Error("Error while getting image", zap.String("image", imageName), zap.Error(err))
+		return
+	}
 	if image.ID == imageId {
 		log.Info("Container is up to date")
 		return
 	}
 	labels["com.docker.compose.image"] = image.ID
+	// Old image is kept for crash loop rollback, the one before it isn't needed anymore
+	previous := labels[dns.PreviousImageLabel]
+	labels[dns.PreviousImageLabel] = imageId
 
-	o.mutex.Lock()
-
-	err := o.removeOldImageAndContainer(ctx, containerId, imageId)
+	err = o.checkPortConflicts(ctx, containerId, o.desiredPortBindings(labels, imageName, hostCfg))
 	if err != nil {
-		log.Error("Error while deleting old image and container", zap.Error(err))
+		log.Error("Can't recreate container", zap.String("container", containerName), zap.Error(err))
+		return
 	}
 
-	err = o.createNewContainer(ctx, imageName, hostCfg, containerName, &labels, endpointsCfg)
-	if err != nil {
+	err = o.replaceContainer(ctx, containerId, containerName, func(ctx context.Context) error {
+		return o.createNewContainer(ctx, imageName, hostCfg, containerName, &labels, endpointsCfg)
+	})
+	if err != nil && !errors.Is(err, errNotStarted) {
 		log.Error("Error while creating new container", zap.Error(err))
+		return
 	}
-	o.mutex.Unlock()
-}
 
-func (o *Operator) getImage(ctx context.Context, imageName string) types.ImageSummary {
-	log := o.log.Named("get_image")
+	if previous == "" || previous == imageId || previous == image.ID {
+		return
+	}
+	_, err = o.client.ImageRemove(ctx, previous, types.ImageRemoveOptions{})
+	if err != nil {
+		log.Warn("Error while deleting previous image", zap.String("image", previous), zap.Error(err))
+	}
+}
 
+func (o *Operator) getImage(ctx context.Context, imageName string) (types.ImageSummary, error) {
 	filters := dockerFilters.NewArgs()
 	filters.Add("reference", imageName)
 
 	images, err := o.client.ImageList(ctx, types.ImageListOptions{Filters: filters})
 	if err != nil {
-		log.Error("Error while getting image", zap.Error(err))
+		return types.ImageSummary{}, err
+	}
+	if len(images) == 0 {
+		return types.ImageSummary{}, fmt.Errorf("no image %s", imageName)
 	}
-	return images[0]
+	return images[0], nil
 }
 
-func (o *Operator) getContainer(ctx context.Context, containerId string) types.Container {
-	log := o.log.Named("get_container")
-
+func (o *Operator) getContainer(ctx context.Context, containerId string) (types.Container, error) {
 	filters := dockerFilters.NewArgs()
 	filters.Add("id", containerId)
 
@@ -518,81 +748,75 @@ func (o *Operator) getContainer(ctx context.Context, containerId string) types.C
 		Filters: filters,
 	})
 	if err != nil {
-		log.Error("Error while getting container", zap.Error(err))
+		return types.Container{}, err
+	}
+	if len(containers) == 0 {
+		return types.Container{}, fmt.Errorf("container %s not found", containerId)
 	}
-	return containers[0]
+	return containers[0], nil
 }
 
-func (o *Operator) getContainerComposeConfig(imageName string) (*dockerContainer.Config, *map[string]struct{}) {
+// getContainerComposeConfig finds compose service of container by its compose service label,
+// containers created without compose are matched by image
+func (o *Operator) getContainerComposeConfig(labels map[string]string, imageName string) (*dockerContainer.Config, nat.PortMap, *map[string]struct{}) {
 	log := o.log.Named("get_container_compose_config")
 
 	composeConfig := readComposeConfig("./docker-compose.yml", log)
 
-	for _, serviceConfig := range composeConfig.Services {
-		if strings.HasSuffix(serviceConfig.Image, imageName) {
-			containerConfig := &dockerContainer.Config{}
-			containerConfig.Image = serviceConfig.Image
-			containerConfig.Env = convertEnvMapToArray(getEnvValues(serviceConfig.Environment))
-			if serviceConfig.Command != "" {
-				containerConfig.Cmd = strings.Split(serviceConfig.Command, " ")
-			}
-			portSet := nat.PortSet{}
-			for _, configPort := range serviceConfig.Ports {
-				port := nat.Port(configPort)
-				portSet[port] = struct{}{}
-			}
-			containerConfig.ExposedPorts = portSet
-			volumesMap := make(map[string]struct{})
-			for _, configVolume := range serviceConfig.Volumes {
-				volumesMap[configVolume] = struct{}{}
-			}
-			containerConfig.Volumes = volumesMap
-			networks := make(map[string]struct{}, 0)
-			for key := range serviceConfig.Networks {
-				networks[o.config.ComposePrefix+key] = struct{}{}
-			}
-
-			return containerConfig, &networks
+	if name, ok := labels[composeServiceLabel]; ok {
+		if serviceConfig, ok := composeConfig.Services[name]; ok {
+			return o.getServiceContainerConfig(name, &serviceConfig)
+		}
+	}
+	if imageName == "" {
+		return nil, nil, nil
+	}
+	for name, serviceConfig := range composeConfig.Services {
+		if strings.HasSuffix(o.serviceImage(name, &serviceConfig), imageName) {
+			return o.getServiceContainerConfig(name, &serviceConfig)
 		}
 	}
 	log.Debug("Container not found", zap.String("image", imageName))
-	return nil, nil
+	return nil, nil, nil
 }
 
-func (o *Operator) removeOldImageAndContainer(ctx context.Context, containerId, imageId string) error {
-	options := dockerContainer.StopOptions{
-		Signal:  "SIGKILL",
-		Timeout: nil,
-	}
-	err := o.client.ContainerStop(ctx, containerId, options)
-	if err != nil {
-		return err
-	}
+func (o *Operator) getServiceContainerConfig(name string, serviceConfig *Service) (*dockerContainer.Config, nat.PortMap, *map[string]struct{}) {
+	log := o.log.Named("get_service_container_config")
 
-	err = o.client.ContainerRemove(ctx, containerId, types.ContainerRemoveOptions{})
-	if err != nil {
-		return err
+	containerConfig := &dockerContainer.Config{}
+	containerConfig.Image = o.serviceImage(name, serviceConfig)
+	containerConfig.Env = convertEnvMapToArray(getEnvValues(serviceConfig.Environment))
+	if serviceConfig.Command != "" {
+		containerConfig.Cmd = strings.Split(serviceConfig.Command, " ")
 	}
-
-	_, err = o.client.ImageRemove(ctx, imageId, types.ImageRemoveOptions{})
+	portSet, portBindings, err := composePorts(serviceConfig)
 	if err != nil {
-		return err
+		log.Error("Error parsing ports", zap.String("image", serviceConfig.Image), zap.Error(err))
+		portSet, portBindings = nat.PortSet{}, nat.PortMap{}
 	}
+	containerConfig.ExposedPorts = portSet
+	containerConfig.Volumes = composeVolumes(o.composeMounts(Config{}, serviceConfig, ""))
+	networks := o.composeNetworks(serviceConfig)
 
-	log := o.log.Named("process_event")
-	names := o.containers[containerId].Names
-	log.Info("Container stopped", zap.String("id", containerId), zap.Strings("names", names))
-	delete(o.containers, containerId)
-
-	return nil
+	return containerConfig, portBindings, &networks
 }
 
 func (o *Operator) createNewContainer(ctx context.Context, imageName string, hostCfg *dockerContainer.HostConfig, containerName string, labels *map[string]string, e *EndpointsConfig) error {
+	containerConfig, portBindings, networksNames := o.getContainerComposeConfig(*labels, imageName)
+	if containerConfig == nil {
+		return fmt.Errorf("no compose service for image %s", imageName)
+	}
+	if len(portBindings) != 0 {
+		hostCfg.PortBindings = portBindings
+	}
+
+	return o.createContainer(ctx, containerConfig, networksNames, hostCfg, containerName, labels, e)
+}
 
-	containerConfig, networksNames := o.getContainerComposeConfig(imageName)
+func (o *Operator) createContainer(ctx context.Context, containerConfig *dockerContainer.Config, networksNames *map[string]struct{}, hostCfg *dockerContainer.HostConfig, containerName string, labels *map[string]string, e *EndpointsConfig) error {
 	containerConfig.Labels = *labels
 
-	if _, ok := containerConfig.Labels[dns.DnsRequiredLabel]; ok {
+	if _, ok := containerConfig.Labels[dns.DnsRequiredLabel]; ok && o.dnsWrap != nil && o.dnsWrap.DnsIp != "" {
 		hostCfg.DNS = []string{o.dnsWrap.DnsIp}
 		hostCfg.DNS = append(hostCfg.DNS, o.defaultDns...)
 		hostCfg.DNSSearch = []string{}
@@ -600,31 +824,45 @@ func (o *Operator) createNewContainer(ctx context.Context, imageName string, hos
 	}
 
 	if _, ok := containerConfig.Labels[dns.WithDriversLabel]; ok {
+		o.containersMutex.RLock()
 		stringDrivers := strings.Join(o.drivers, " ")
+		o.containersMutex.RUnlock()
 		containerConfig.Env = append(containerConfig.Env, fmt.Sprintf("DRIVERS=%s", stringDrivers))
 	}
 
-	create, err := o.client.ContainerCreate(ctx, containerConfig, hostCfg, nil, nil, containerName)
+	if !hostCfg.NetworkMode.IsContainer() && !hostCfg.NetworkMode.IsHost() && !hostCfg.NetworkMode.IsNone() {
+		for name := range *networksNames {
+			e.Add(name)
+		}
+		if e.Primary != "" {
+			hostCfg.NetworkMode = dockerContainer.NetworkMode(e.Primary)
+		}
+	}
+
+	create, err := o.client.ContainerCreate(ctx, containerConfig, hostCfg, e.NetworkingConfig(), nil, containerName)
 	if err != nil {
 		return err
 	}
 
-	if err := o.client.ContainerStart(ctx, create.ID, types.ContainerStartOptions{}); err != nil {
-		o.notRunningContainers = append(o.notRunningContainers, create.ID)
-		o.networkNames[create.ID] = networksNames
-		o.endpoints[create.ID] = e
+	err = o.connectNetworks(ctx, create.ID, e)
+	if err != nil {
 		return err
 	}
 
-	container := o.getContainer(ctx, create.ID)
-	containerInfo := NewContainerInfo(&container)
-
-	o.containers[containerInfo.Id] = *containerInfo
+	if err := o.client.ContainerStart(ctx, create.ID, types.ContainerStartOptions{}); err != nil {
+		o.markNotRunning(ctx, create.ID, containerName, err)
+		return fmt.Errorf("%w: %w", errNotStarted, err)
+	}
 
-	err = o.connectNetworks(ctx, create.ID, networksNames, e)
+	container, err := o.getContainer(ctx, create.ID)
 	if err != nil {
 		return err
 	}
+	containerInfo := NewContainerInfo(&container)
+
+	o.setContainer(*containerInfo)
+
+	o.reconcileDns(ctx)
 
 	return nil
 }
@@ -645,66 +883,17 @@ func (o *Operator) getIpInNetwork(ctx context.Context, containerId string, netwo
 	}
 }
 
-func (o *Operator) connectNetworks(ctx context.Context, containerId string, endpointsNames *map[string]struct{}, config *EndpointsConfig) error {
-	networksList, err := o.client.NetworkList(ctx, types.NetworkListOptions{})
-	if err != nil {
-		return err
-	}
-	networkIds := getNecessaryNetworks(&networksList, *endpointsNames)
-
-	inspectedContainer, err := o.client.ContainerInspect(ctx, containerId)
-	if err != nil {
-		return err
-	}
-
-	for _, value := range inspectedContainer.NetworkSettings.Networks {
-		o.client.NetworkDisconnect(ctx, value.NetworkID, containerId, true)
-	}
-
-	for _, id := range networkIds {
-		o.client.NetworkConnect(ctx, id, containerId, &network.EndpointSettings{
-			Links:     config.Links,
-			Aliases:   config.Aliases,
-			IPAddress: config.IPAddress,
-			Gateway:   config.Gateway,
-		})
-	}
-
-	o.configureDnsMgmtRecords(ctx, containerId)
-
-	return nil
-}
-
-func (o *Operator) configureDnsMgmtRecords(ctx context.Context, id string) {
-	log := o.log.Named("configure_dns_mgmt_records")
-
-	container, _, err := o.client.ContainerInspectWithRaw(ctx, id, false)
-	if err != nil {
-		return
-	}
-	labels := container.Config.Labels
-	if zoneLabelValue, ok := labels[dns.ZoneLabel]; ok {
-
-		log.Info("ContainerId", zap.String("id", id))
-		log.Info("Container got zone label")
-
-		net := labels[dns.NetworkLabel]
-
-		ip, err := o.getIpInNetwork(ctx, id, net) // TODO: handle error
-
+// connectNetworks attaches created container to all of its networks except the primary one,
+// which is already set on creation
+func (o *Operator) connectNetworks(ctx context.Context, containerId string, config *EndpointsConfig) error {
+	for _, name := range config.Secondary() {
+		err := o.client.NetworkConnect(ctx, name, containerId, config.Networks[name])
 		if err != nil {
-			log.Error("Fail to get ip in zone", zap.String("container id", id))
-			return
-		}
-
-		log.Info("Ip in net", zap.String("ip", ip), zap.String("net", net))
-
-		aValue := labels[dns.ALabel]
-		err = o.dnsWrap.Get(ctx, zoneLabelValue, ip, aValue)
-		if err != nil {
-			log.Error("DNS Error", zap.Error(err))
+			return fmt.Errorf("connecting to network %s: %w", name, err)
 		}
 	}
+
+	return nil
 }
 
 func readComposeConfig(path string, log *zap.Logger) Config {
@@ -721,16 +910,6 @@ func readComposeConfig(path string, log *zap.Logger) Config {
 	return data
 }
 
-func getNecessaryNetworks(list *[]types.NetworkResource, endpointsNames map[string]struct{}) []string {
-	networkIds := make([]string, 0)
-	for _, item := range *list {
-		if _, ok := endpointsNames[item.Name]; ok {
-			networkIds = append(networkIds, item.ID)
-		}
-	}
-	return networkIds
-}
-
 func convertEnvMapToArray(envMap map[string]string) []string {
 	result := make([]string, 0)
 
@@ -767,27 +946,3 @@ func getEnvValue(value string) string {
 
 	return string(result)
 }
-
-func getLinksAndAliases(networks map[string]*network.EndpointSettings, id string) *EndpointsConfig {
-	for _, value := range networks {
-		var links = value.Links
-		var aliases = value.Aliases
-
-		if len(aliases) != 0 {
-			for i, value := range aliases {
-				if strings.HasPrefix(id, value) {
-					aliases = append(aliases[:i], aliases[i+1:]...)
-					break
-				}
-			}
-		}
-
-		return &EndpointsConfig{
-			Links:     links,
-			Aliases:   aliases,
-			IPAddress: value.IPAddress,
-			Gateway:   value.Gateway,
-		}
-	}
-	return nil
-}
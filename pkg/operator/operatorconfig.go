@@ -1,14 +1,125 @@
 package operator
 
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is read from Go duration syntax (10s, 5m), plain number is taken as seconds
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if seconds, err := strconv.Atoi(value.Value); err == nil {
+		*d = Duration(time.Duration(seconds) * time.Second)
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Or returns duration, or def if duration isn't set
+func (d Duration) Or(def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
 type Registries struct {
 	Username      string `yaml:"username" json:"username"`
 	Password      string `yaml:"password" json:"password"`
 	ServerAddress string `yaml:"serverAddress" json:"server_address"`
 }
 
+type RetryConfig struct {
+	Attempts int      `yaml:"attempts"`
+	Backoff  Duration `yaml:"backoff"`
+	MaxDelay Duration `yaml:"maxDelay"`
+}
+
+type AlertsConfig struct {
+	Webhook string `yaml:"webhook"`
+}
+
+type StatusConfig struct {
+	Address string `yaml:"address"`
+}
+
+type LeaderConfig struct {
+	Backend string   `yaml:"backend"`
+	Key     string   `yaml:"key"`
+	Path    string   `yaml:"path"`
+	Ttl     Duration `yaml:"ttl"`
+}
+
+type CrashLoopConfig struct {
+	Restarts int      `yaml:"restarts"`
+	Window   Duration `yaml:"window"`
+	Action   string   `yaml:"action"`
+}
+
+type HealthConfig struct {
+	Threshold int      `yaml:"threshold"`
+	Cooldown  Duration `yaml:"cooldown"`
+}
+
+type SelfUpdateConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Container string   `yaml:"container"`
+	Timeout   Duration `yaml:"timeout"`
+	Interval  Duration `yaml:"interval"`
+}
+
+type DnsZoneConfig struct {
+	Ttl Duration `yaml:"ttl"`
+}
+
+type DnsRecordsConfig struct {
+	GracePeriod  Duration                 `yaml:"gracePeriod"`
+	Owner        string                   `yaml:"owner"`
+	Ptr          bool                     `yaml:"ptr"`
+	ReverseZones []string                 `yaml:"reverseZones"`
+	Ttl          Duration                 `yaml:"ttl"`
+	Zones        map[string]DnsZoneConfig `yaml:"zones"`
+	Audit        string                   `yaml:"audit"`
+}
+
+type DnsProviderConfig struct {
+	Type          string   `yaml:"type"`
+	Server        string   `yaml:"server"`
+	Zones         []string `yaml:"zones"`
+	TsigKey       string   `yaml:"tsigKey"`
+	TsigSecret    string   `yaml:"tsigSecret"`
+	TsigAlgorithm string   `yaml:"tsigAlgorithm"`
+	Directory     string   `yaml:"directory"`
+	Format        string   `yaml:"format"`
+	Listen        string   `yaml:"listen"`
+	Network       string   `yaml:"network"`
+}
+
 type OperatorConfig struct {
-	Duration         int          `yaml:"duration"`
-	ComposePrefix    string       `yaml:"composePrefix"`
-	DockerRegistries []Registries `yaml:"registries"`
-	Dns              []string     `yaml:"dns"`
+	Duration         Duration          `yaml:"duration"`
+	Jitter           Duration          `yaml:"jitter"`
+	ComposePrefix    string            `yaml:"composePrefix"`
+	DockerRegistries []Registries      `yaml:"registries"`
+	Dns              []string          `yaml:"dns"`
+	Retry            RetryConfig       `yaml:"retry"`
+	Alerts           AlertsConfig      `yaml:"alerts"`
+	Reconcile        string            `yaml:"reconcile"`
+	Status           StatusConfig      `yaml:"status"`
+	ShutdownTimeout  Duration          `yaml:"shutdownTimeout"`
+	Leader           LeaderConfig      `yaml:"leader"`
+	CrashLoop        CrashLoopConfig   `yaml:"crashLoop"`
+	Health           HealthConfig      `yaml:"health"`
+	SelfUpdate       SelfUpdateConfig  `yaml:"selfUpdate"`
+	DnsRecords       DnsRecordsConfig  `yaml:"dnsRecords"`
+	DnsProvider      DnsProviderConfig `yaml:"dnsProvider"`
 }
@@ -0,0 +1,141 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	dockerContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"gopkg.in/yaml.v3"
+)
+
+// ServicePort is compose ports entry, either short ("127.0.0.1:8080-8081:80-81/tcp")
+// or long syntax (mapping with target, published, host_ip and protocol)
+type ServicePort struct {
+	Target    string `yaml:"target"`
+	Published string `yaml:"published"`
+	HostIp    string `yaml:"host_ip"`
+	Protocol  string `yaml:"protocol"`
+	Mode      string `yaml:"mode"`
+
+	spec string
+}
+
+func (p *ServicePort) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		p.spec = value.Value
+		return nil
+	}
+
+	type plain ServicePort
+	if err := value.Decode((*plain)(p)); err != nil {
+		return err
+	}
+	if p.Target == "" {
+		return fmt.Errorf("line %d: port target is required", value.Line)
+	}
+	return nil
+}
+
+// Spec returns port in docker ip:public:private/proto format
+func (p ServicePort) Spec() string {
+	if p.spec != "" {
+		return p.spec
+	}
+
+	spec := p.Target
+	if p.Published != "" {
+		spec = p.Published + ":" + spec
+		if p.HostIp != "" {
+			hostIp := p.HostIp
+			if strings.Contains(hostIp, ":") && !strings.HasPrefix(hostIp, "[") {
+				hostIp = "[" + hostIp + "]"
+			}
+			spec = hostIp + ":" + spec
+		}
+	}
+	if p.Protocol != "" {
+		spec += "/" + p.Protocol
+	}
+	return spec
+}
+
+func (p ServicePort) String() string {
+	return p.Spec()
+}
+
+func composePorts(service *Service) (nat.PortSet, nat.PortMap, error) {
+	specs := make([]string, 0, len(service.Ports))
+	for _, port := range service.Ports {
+		specs = append(specs, port.Spec())
+	}
+	return nat.ParsePortSpecs(specs)
+}
+
+// desiredPortBindings returns bindings container would be recreated with
+func (o *Operator) desiredPortBindings(labels map[string]string, imageName string, hostCfg *dockerContainer.HostConfig) nat.PortMap {
+	_, portBindings, _ := o.getContainerComposeConfig(labels, imageName)
+	if len(portBindings) != 0 {
+		return portBindings
+	}
+	return hostCfg.PortBindings
+}
+
+// checkPortConflicts returns error if any host port from bindings is already published
+// by running container other than the one with given id
+func (o *Operator) checkPortConflicts(ctx context.Context, containerId string, bindings nat.PortMap) error {
+	if len(bindings) == 0 {
+		return nil
+	}
+
+	containersList, err := o.client.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containersList {
+		if container.ID == containerId {
+			continue
+		}
+		for _, published := range container.Ports {
+			if published.PublicPort == 0 {
+				continue
+			}
+			for port, items := range bindings {
+				if port.Proto() != published.Type {
+					continue
+				}
+				for _, binding := range items {
+					if binding.HostPort == "" || !hostIpsOverlap(binding.HostIP, published.IP) {
+						continue
+					}
+					start, end, err := nat.ParsePortRangeToInt(binding.HostPort)
+					if err != nil {
+						return err
+					}
+					// Range without fixed port lets Docker pick any free one
+					if start != end {
+						continue
+					}
+					if int(published.PublicPort) == start {
+						name := container.ID[:6]
+						if len(container.Names) != 0 {
+							name = strings.TrimPrefix(container.Names[0], "/")
+						}
+						return fmt.Errorf("host port %s/%s is already published by container %s", binding.HostPort, port.Proto(), name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hostIpsOverlap(a, b string) bool {
+	wildcard := func(ip string) bool {
+		return ip == "" || ip == "0.0.0.0" || ip == "::"
+	}
+	return wildcard(a) || wildcard(b) || a == b
+}
@@ -0,0 +1,43 @@
+package operator
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestServicePortSpec(t *testing.T) {
+	cases := []struct {
+		yaml string
+		spec string
+		err  bool
+	}{
+		{yaml: `"127.0.0.1:8080-8081:80-81/tcp"`, spec: "127.0.0.1:8080-8081:80-81/tcp"},
+		{yaml: `"80"`, spec: "80"},
+		{yaml: `{target: 80}`, spec: "80"},
+		{yaml: `{target: 80, published: "8080"}`, spec: "8080:80"},
+		{yaml: `{target: 53, published: "53", protocol: udp}`, spec: "53:53/udp"},
+		{yaml: `{target: 80, published: "8080", host_ip: 127.0.0.1}`, spec: "127.0.0.1:8080:80"},
+		{yaml: `{target: 80, published: "8080", host_ip: "::1"}`, spec: "[::1]:8080:80"},
+		{yaml: `{target: 80, host_ip: 127.0.0.1}`, spec: "80"},
+		{yaml: `{published: "8080"}`, err: true},
+	}
+
+	for _, c := range cases {
+		var port ServicePort
+		err := yaml.Unmarshal([]byte(c.yaml), &port)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: no error", c.yaml)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.yaml, err)
+			continue
+		}
+		if spec := port.Spec(); spec != c.spec {
+			t.Errorf("%s: spec = %q, want %q", c.yaml, spec, c.spec)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRetryAttempts = 5
+	defaultRetryBackoff  = 5 * time.Second
+	defaultRetryMaxDelay = 300 * time.Second
+
+	failedLogsTail = "50"
+)
+
+// NotRunningContainer is a container operator created but failed to start
+type NotRunningContainer struct {
+	Id       string
+	Name     string
+	Attempts int
+	NextTry  time.Time
+	Reason   string
+	Logs     string
+	// Failed is set once all retries are exhausted
+	Failed bool
+}
+
+func (o *Operator) markNotRunning(ctx context.Context, id, name string, err error) {
+	o.retryMutex.Lock()
+	defer o.retryMutex.Unlock()
+
+	container, ok := o.notRunningContainers[id]
+	if !ok {
+		container = &NotRunningContainer{Id: id, Name: name}
+		o.notRunningContainers[id] = container
+	}
+	container.Reason, container.Logs = o.failureReason(ctx, id, err)
+	container.NextTry = time.Now().Add(o.retryDelay(container.Attempts))
+}
+
+// NotRunningContainers returns copy of containers failed to start
+func (o *Operator) NotRunningContainers() []NotRunningContainer {
+	o.retryMutex.Lock()
+	defer o.retryMutex.Unlock()
+
+	result := make([]NotRunningContainer, 0, len(o.notRunningContainers))
+	for _, container := range o.notRunningContainers {
+		result = append(result, *container)
+	}
+	return result
+}
+
+// retryNotRunning tries to start containers failed to start with growing delay,
+// marking them failed and alerting once attempts are exhausted. Failed containers are forgotten
+// once they're gone or started by other means
+func (o *Operator) retryNotRunning(ctx context.Context) {
+	log := o.log.Named("retry_not_running")
+
+	var alerts []Alert
+	defer func() {
+		for _, alert := range alerts {
+			o.alert(ctx, alert)
+		}
+	}()
+
+	o.retryMutex.Lock()
+	defer o.retryMutex.Unlock()
+
+	attempts := o.config.Retry.Attempts
+	if attempts <= 0 {
+		attempts = defaultRetryAttempts
+	}
+
+	for id, container := range o.notRunningContainers {
+		if !container.Failed && time.Now().Before(container.NextTry) {
+			continue
+		}
+
+		inspect, _, err := o.client.ContainerInspectWithRaw(ctx, id, false)
+		if err != nil {
+			log.Warn("Container is gone, stop retrying", zap.String("id", id), zap.Error(err))
+			delete(o.notRunningContainers, id)
+			continue
+		}
+		if inspect.State.Running {
+			log.Info("Container is running", zap.String("id", id), zap.String("name", container.Name))
+			delete(o.notRunningContainers, id)
+			continue
+		}
+		if container.Failed {
+			continue
+		}
+
+		container.Attempts++
+		log.Info("Retry starting container", zap.String("id", id), zap.String("name", container.Name), zap.Int("attempt", container.Attempts))
+
+		err = o.client.ContainerStart(ctx, id, types.ContainerStartOptions{})
+		if err == nil {
+			log.Info("Container started", zap.String("id", id), zap.String("name", container.Name))
+			delete(o.notRunningContainers, id)
+			o.reconcileDns(ctx)
+			continue
+		}
+
+		container.Reason, container.Logs = o.failureReason(ctx, id, err)
+		log.Error("Fail to start container", zap.String("id", id), zap.String("name", container.Name), zap.String("reason", container.Reason))
+
+		if container.Attempts >= attempts {
+			container.Failed = true
+			alerts = append(alerts, Alert{
+				ContainerId: id,
+				Name:        container.Name,
+				Reason:      fmt.Sprintf("failed to start after %d attempts: %s", container.Attempts, container.Reason),
+				Logs:        container.Logs,
+			})
+			continue
+		}
+
+		container.NextTry = time.Now().Add(o.retryDelay(container.Attempts))
+	}
+}
+
+func (o *Operator) retryDelay(attempts int) time.Duration {
+	maxDelay := o.config.Retry.MaxDelay.Or(defaultRetryMaxDelay)
+
+	delay := o.config.Retry.Backoff.Or(defaultRetryBackoff)
+	for i := 0; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// failureReason combines start error with container state and returns recent container logs
+func (o *Operator) failureReason(ctx context.Context, id string, err error) (string, string) {
+	reason := err.Error()
+
+	inspect, _, inspectErr := o.client.ContainerInspectWithRaw(ctx, id, false)
+	if inspectErr != nil {
+		return reason, ""
+	}
+	if inspect.State.Error != "" && !strings.Contains(reason, inspect.State.Error) {
+		reason = fmt.Sprintf("%s (state: %s)", reason, inspect.State.Error)
+	}
+
+	out, logsErr := o.client.ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       failedLogsTail,
+	})
+	if logsErr != nil {
+		return reason, ""
+	}
+	defer out.Close()
+
+	var logs bytes.Buffer
+	if inspect.Config.Tty {
+		_, _ = logs.ReadFrom(out)
+	} else {
+		_, _ = stdcopy.StdCopy(&logs, &logs, out)
+	}
+	return reason, logs.String()
+}
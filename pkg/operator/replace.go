@@ -0,0 +1,89 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	dockerContainer "github.com/docker/docker/api/types/container"
+	"go.uber.org/zap"
+)
+
+const replacedSuffix = "_replaced"
+
+// errNotStarted is returned when replacement was created but failed to start,
+// such container is left to retry reconciler instead of rolling back
+var errNotStarted = errors.New("container created but not started")
+
+// replaceContainer stops container and creates its replacement under the same name. Old container is
+// kept renamed until replacement is created, so it's brought back if creation fails or runs out of time
+// on shutdown. Replacements are made one at a time, so they don't race on renaming the same container
+func (o *Operator) replaceContainer(ctx context.Context, id, name string, create func(ctx context.Context) error) error {
+	log := o.log.Named("replace_container")
+	name = strings.TrimPrefix(name, "/")
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	ctx, cancel := o.recreationContext(ctx)
+	defer cancel()
+
+	options := dockerContainer.StopOptions{
+		Signal:  "SIGKILL",
+		Timeout: nil,
+	}
+	o.expectStop(id)
+	err := o.client.ContainerStop(ctx, id, options)
+	if err != nil {
+		return err
+	}
+
+	err = o.client.ContainerRename(ctx, id, name+replacedSuffix)
+	if err != nil {
+		if startErr := o.client.ContainerStart(ctx, id, types.ContainerStartOptions{}); startErr != nil {
+			log.Error("Fail to start container back", zap.String("id", id), zap.Error(startErr))
+		}
+		return err
+	}
+
+	log.Info("Container stopped", zap.String("id", id), zap.String("name", name))
+	o.forgetContainer(id)
+
+	err = create(ctx)
+	if err == nil {
+		o.dependencyRecreated(ctx, name)
+	}
+	if err == nil || (errors.Is(err, errNotStarted) && ctx.Err() == nil) {
+		if removeErr := o.client.ContainerRemove(ctx, id, types.ContainerRemoveOptions{}); removeErr != nil {
+			log.Warn("Fail to remove replaced container", zap.String("id", id), zap.Error(removeErr))
+		}
+		return err
+	}
+
+	log.Error("Fail to create replacement, rolling back", zap.String("id", id), zap.String("name", name), zap.Error(err))
+	if rollbackErr := o.rollbackReplace(ctx, id, name); rollbackErr != nil {
+		return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+	}
+	return err
+}
+
+func (o *Operator) rollbackReplace(ctx context.Context, id, name string) error {
+	ctx, cancel := context.WithTimeout(detachedContext{parent: ctx}, o.rollbackTimeout())
+	defer cancel()
+
+	if replacement, err := o.client.ContainerInspect(ctx, name); err == nil && replacement.ID != id {
+		err = o.client.ContainerRemove(ctx, replacement.ID, types.ContainerRemoveOptions{Force: true})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := o.client.ContainerRename(ctx, id, name)
+	if err != nil {
+		return err
+	}
+
+	return o.client.ContainerStart(ctx, id, types.ContainerStartOptions{})
+}
@@ -0,0 +1,227 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	dockerContainer "github.com/docker/docker/api/types/container"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/slntopp/nocloud-operator/pkg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	// SelfUpdateCommand makes operator binary run as self-update helper
+	SelfUpdateCommand = "self-update"
+
+	updaterSuffix            = "_updater"
+	defaultSelfUpdateTimeout = 120 * time.Second
+	selfUpdatePollInterval   = 2 * time.Second
+	// selfUpdateStopMargin is added to shutdown timeout operator is stopped with, so it exits by itself
+	selfUpdateStopMargin = 5 * time.Second
+)
+
+// selfContainer finds container operator runs in, by configured name or by hostname Docker sets to container id
+func (o *Operator) selfContainer(ctx context.Context) (types.ContainerJSON, error) {
+	name := o.config.SelfUpdate.Container
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return types.ContainerJSON{}, err
+		}
+		name = hostname
+	}
+	return o.client.ContainerInspect(ctx, name)
+}
+
+// checkSelfUpdate pulls operator image and, if it changed, hands replacement of operator
+// over to helper container started from the new image
+func (o *Operator) checkSelfUpdate(ctx context.Context) {
+	log := o.log.Named("self_update")
+	if !o.config.SelfUpdate.Enabled {
+		return
+	}
+
+	self, err := o.selfContainer(ctx)
+	if err != nil {
+		log.Warn("Can't find operator container", zap.Error(err))
+		return
+	}
+	name := strings.TrimPrefix(self.Name, "/")
+
+	if _, err := o.client.ContainerInspect(ctx, name+updaterSuffix); err == nil {
+		log.Info("Self update is already in progress")
+		return
+	}
+
+	o.pullImage(ctx, self.Config.Image)
+	image, _, err := o.client.ImageInspectWithRaw(ctx, self.Config.Image)
+	if err != nil {
+		log.Error("Error inspecting operator image", zap.Error(err))
+		return
+	}
+	if image.ID == self.Image {
+		return
+	}
+
+	log.Info("New operator image, starting updater", zap.String("image", self.Config.Image), zap.String("id", image.ID))
+
+	helper, err := o.client.ContainerCreate(ctx, &dockerContainer.Config{
+		Image: image.ID,
+		Env:   self.Config.Env,
+		Cmd:   []string{SelfUpdateCommand, self.ID, o.selfUpdateTimeout().String(), (o.ShutdownTimeout() + selfUpdateStopMargin).String()},
+	}, &dockerContainer.HostConfig{
+		Binds:      self.HostConfig.Binds,
+		Mounts:     self.HostConfig.Mounts,
+		AutoRemove: true,
+	}, nil, nil, name+updaterSuffix)
+	if err != nil {
+		log.Error("Error creating updater container", zap.Error(err))
+		return
+	}
+
+	if err := o.client.ContainerStart(ctx, helper.ID, types.ContainerStartOptions{}); err != nil {
+		log.Error("Error starting updater container", zap.Error(err))
+		_ = o.client.ContainerRemove(ctx, helper.ID, types.ContainerRemoveOptions{Force: true})
+	}
+}
+
+// ConfirmSelfUpdate removes previous operator container left by updater, which tells updater
+// that the new instance has started
+func (o *Operator) ConfirmSelfUpdate(ctx context.Context) {
+	log := o.log.Named("confirm_self_update")
+	if !o.config.SelfUpdate.Enabled {
+		return
+	}
+
+	self, err := o.selfContainer(ctx)
+	if err != nil {
+		log.Warn("Can't find operator container", zap.Error(err))
+		return
+	}
+
+	previous, err := o.client.ContainerInspect(ctx, strings.TrimPrefix(self.Name, "/")+replacedSuffix)
+	if err != nil || previous.ID == self.ID {
+		return
+	}
+
+	if self.State.Health != nil && !o.awaitHealthy(ctx, self.ID) {
+		log.Error("Operator isn't healthy, leaving update to roll back")
+		return
+	}
+
+	err = o.client.ContainerRemove(ctx, previous.ID, types.ContainerRemoveOptions{Force: true})
+	if err != nil {
+		log.Error("Error removing previous operator container", zap.Error(err))
+		return
+	}
+	log.Info("Self update confirmed", zap.String("image", self.Config.Image))
+}
+
+func (o *Operator) awaitHealthy(ctx context.Context, id string) bool {
+	deadline := time.Now().Add(o.selfUpdateTimeout())
+	for time.Now().Before(deadline) {
+		container, err := o.client.ContainerInspect(ctx, id)
+		if err == nil && container.State.Health != nil && container.State.Health.Status == types.Healthy {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(selfUpdatePollInterval):
+		}
+	}
+	return false
+}
+
+func (o *Operator) selfUpdateTimeout() time.Duration {
+	return o.config.SelfUpdate.Timeout.Or(defaultSelfUpdateTimeout)
+}
+
+// RunSelfUpdate is run by updater container. It stops operator container, giving it stopTimeout to shut down
+// gracefully, creates the new one from same config and waits timeout for it to confirm start, otherwise the
+// old container is brought back
+func RunSelfUpdate(ctx context.Context, logger *zap.Logger, id string, timeout, stopTimeout time.Duration) error {
+	log := logger.Named("self_updater")
+	client, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv, dockerClient.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	old, err := client.ContainerInspect(ctx, id)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(old.Name, "/")
+
+	log.Info("Stopping operator", zap.String("name", name), zap.Duration("timeout", stopTimeout))
+	stopSeconds := int(stopTimeout / time.Second)
+	if err := client.ContainerStop(ctx, id, dockerContainer.StopOptions{Timeout: &stopSeconds}); err != nil {
+		return err
+	}
+	if err := client.ContainerRename(ctx, id, name+replacedSuffix); err != nil {
+		_ = client.ContainerStart(ctx, id, types.ContainerStartOptions{})
+		return err
+	}
+
+	config := old.Config
+	// Hostname defaulted to old container id has to be generated for the new one
+	if strings.HasPrefix(old.ID, config.Hostname) {
+		config.Hostname = ""
+	}
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	config.Labels[dns.PreviousImageLabel] = old.Image
+	endpointsConfig := NewEndpointsConfig(old.HostConfig.NetworkMode, old.NetworkSettings.Networks, old.ID)
+
+	created, err := client.ContainerCreate(ctx, config, old.HostConfig, endpointsConfig.NetworkingConfig(), nil, name)
+	if err != nil {
+		log.Error("Error creating operator", zap.Error(err))
+		return restoreOperator(ctx, client, id, name, "")
+	}
+	for _, network := range endpointsConfig.Secondary() {
+		if err := client.NetworkConnect(ctx, network, created.ID, endpointsConfig.Networks[network]); err != nil {
+			log.Error("Error connecting operator to network", zap.String("network", network), zap.Error(err))
+			return restoreOperator(ctx, client, id, name, created.ID)
+		}
+	}
+	if err := client.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		log.Error("Error starting operator", zap.Error(err))
+		return restoreOperator(ctx, client, id, name, created.ID)
+	}
+
+	// New operator removes the old container once it started
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := client.ContainerInspect(ctx, id); dockerClient.IsErrNotFound(err) {
+			log.Info("Operator updated", zap.String("id", created.ID))
+			return nil
+		}
+		time.Sleep(selfUpdatePollInterval)
+	}
+
+	log.Error("Operator didn't confirm update in time", zap.Duration("timeout", timeout))
+	return restoreOperator(ctx, client, id, name, created.ID)
+}
+
+// restoreOperator removes failed operator container and brings the old one back
+func restoreOperator(ctx context.Context, client *dockerClient.Client, id, name, createdId string) error {
+	if createdId != "" {
+		if err := client.ContainerRemove(ctx, createdId, types.ContainerRemoveOptions{Force: true}); err != nil {
+			return err
+		}
+	}
+	if err := client.ContainerRename(ctx, id, name); err != nil {
+		return err
+	}
+	if err := client.ContainerStart(ctx, id, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+	return fmt.Errorf("operator update rolled back")
+}
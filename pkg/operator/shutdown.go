@@ -0,0 +1,50 @@
+package operator
+
+import (
+	"context"
+	"time"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// detachedContext keeps parent values (like auth metadata) but isn't cancelled with parent
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+func (o *Operator) ShutdownTimeout() time.Duration {
+	return o.config.ShutdownTimeout.Or(defaultShutdownTimeout)
+}
+
+// recreationContext lets recreation started before shutdown finish, bounded by shutdown timeout. Once shutdown
+// starts or leadership is lost, recreation gets half of shutdown timeout, so its rollback still fits before
+// operator exits
+func (o *Operator) recreationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	recreation, cancel := context.WithTimeout(detachedContext{parent: ctx}, o.ShutdownTimeout())
+	go func() {
+		select {
+		case <-recreation.Done():
+			return
+		case <-ctx.Done():
+		}
+
+		timer := time.NewTimer(o.ShutdownTimeout() / 2)
+		defer timer.Stop()
+		select {
+		case <-recreation.Done():
+		case <-timer.C:
+			cancel()
+		}
+	}()
+	return recreation, cancel
+}
+
+// rollbackTimeout is time rollback of failed recreation gets, third of shutdown timeout
+func (o *Operator) rollbackTimeout() time.Duration {
+	return o.ShutdownTimeout() / 3
+}
@@ -0,0 +1,66 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+type Status struct {
+	Leader       LeaderStatus          `json:"leader"`
+	NotRunning   []NotRunningContainer `json:"not_running"`
+	Drift        []DriftReport         `json:"drift"`
+	CrashLoops   []CrashLoop           `json:"crash_loops"`
+	Remediations []Remediation         `json:"remediations"`
+	DnsConflicts []DnsConflict         `json:"dns_conflicts"`
+}
+
+func (o *Operator) Status() Status {
+	return Status{
+		Leader:       o.LeaderStatus(),
+		NotRunning:   o.NotRunningContainers(),
+		Drift:        o.DriftReports(),
+		CrashLoops:   o.CrashLoops(),
+		Remediations: o.Remediations(),
+		DnsConflicts: o.DnsConflicts(),
+	}
+}
+
+// ServeStatus serves operator status as JSON on configured address until ctx is cancelled,
+// does nothing if address isn't set
+func (o *Operator) ServeStatus(ctx context.Context) {
+	log := o.log.Named("status")
+	if o.config.Status.Address == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(o.Status()); err != nil {
+			log.Error("Error encoding status", zap.Error(err))
+		}
+	})
+	mux.HandleFunc("/drift", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(o.DriftReports()); err != nil {
+			log.Error("Error encoding drift reports", zap.Error(err))
+		}
+	})
+
+	server := &http.Server{Addr: o.config.Status.Address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), o.ShutdownTimeout())
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.Info("Serving status", zap.String("address", o.config.Status.Address))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("Status server stopped", zap.Error(err))
+	}
+}
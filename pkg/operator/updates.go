@@ -0,0 +1,111 @@
+package operator
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/slntopp/nocloud-operator/pkg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultUpdateInterval     = 10 * time.Second
+	defaultSelfUpdateInterval = 5 * time.Minute
+)
+
+// updateSchedule is when image of container is checked next, kept by name as updates change container id
+type updateSchedule struct {
+	next    time.Time
+	running bool
+}
+
+// checkUpdates starts image check of every container which is due. First checks are spread over
+// the interval and every next one is delayed by random jitter, so registry isn't hit by bursts
+func (o *Operator) checkUpdates(ctx context.Context) {
+	if o.selfUpdateDue() {
+		o.checkSelfUpdate(ctx)
+	}
+
+	o.updatesMutex.Lock()
+	defer o.updatesMutex.Unlock()
+
+	now := time.Now()
+	seen := map[string]struct{}{}
+	for _, container := range o.containersCopy() {
+		if _, ok := container.Labels[dns.UpdateLabel]; !ok || len(container.Names) == 0 {
+			continue
+		}
+		name := container.Names[0]
+		seen[name] = struct{}{}
+
+		schedule, ok := o.updates[name]
+		if !ok {
+			interval := o.updateInterval(container.Labels)
+			schedule = &updateSchedule{next: now.Add(time.Duration(rand.Int63n(int64(interval))))}
+			o.updates[name] = schedule
+		}
+		if schedule.running || now.Before(schedule.next) {
+			continue
+		}
+
+		schedule.running = true
+		o.updatesWg.Add(1)
+		go func(id, name string, labels map[string]string) {
+			o.checkHash(ctx, id, name, &o.updatesWg)
+
+			o.updatesMutex.Lock()
+			defer o.updatesMutex.Unlock()
+			schedule.running = false
+			schedule.next = time.Now().Add(o.updateInterval(labels) + o.updateJitter())
+		}(container.Id, name, container.Labels)
+	}
+
+	for name, schedule := range o.updates {
+		if _, ok := seen[name]; !ok && !schedule.running {
+			delete(o.updates, name)
+		}
+	}
+}
+
+// selfUpdateDue tells if operator image should be checked now, first check is spread over the interval
+// as checks of other containers are
+func (o *Operator) selfUpdateDue() bool {
+	if !o.config.SelfUpdate.Enabled {
+		return false
+	}
+
+	o.updatesMutex.Lock()
+	defer o.updatesMutex.Unlock()
+
+	now := time.Now()
+	interval := o.config.SelfUpdate.Interval.Or(defaultSelfUpdateInterval)
+	if o.selfUpdateNext.IsZero() {
+		o.selfUpdateNext = now.Add(time.Duration(rand.Int63n(int64(interval))))
+		return false
+	}
+	if now.Before(o.selfUpdateNext) {
+		return false
+	}
+	o.selfUpdateNext = now.Add(interval + o.updateJitter())
+	return true
+}
+
+// updateInterval is taken from nocloud.update.interval label, falling back to configured duration
+func (o *Operator) updateInterval(labels map[string]string) time.Duration {
+	if value, ok := labels[dns.UpdateIntervalLabel]; ok {
+		interval, err := time.ParseDuration(value)
+		if err == nil && interval > 0 {
+			return interval
+		}
+		o.log.Named("update_interval").Warn("Wrong update interval", zap.String("value", value), zap.Error(err))
+	}
+	return o.config.Duration.Or(defaultUpdateInterval)
+}
+
+func (o *Operator) updateJitter() time.Duration {
+	if o.config.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(o.config.Jitter)))
+}